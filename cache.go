@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of an entry in --cache-dir, which is a folder named after the
+// cache key
+const (
+	cacheResultFile = "result.json"
+	cacheFilesDir   = "files"
+)
+
+// cachedCompile is what is kept of a compile's Result.
+type cachedCompile struct {
+	Status Status `json:"status"`
+	Out    string `json:"out,omitempty"`
+	Err    string `json:"err,omitempty"`
+}
+
+// compilerVersions remembers the output of each version command, so the
+// compiler is only asked once a run.
+var compilerVersions = struct {
+	mu       sync.Mutex
+	versions map[string]string
+	errs     map[string]error
+}{versions: make(map[string]string), errs: make(map[string]error)}
+
+// compilerVersion runs command, e.g. javac -version, and returns what it
+// printed.
+func compilerVersion(command []string) (string, error) {
+	name := strings.Join(command, " ")
+	compilerVersions.mu.Lock()
+	defer compilerVersions.mu.Unlock()
+	if version, ok := compilerVersions.versions[name]; ok {
+		return version, nil
+	}
+	if err, ok := compilerVersions.errs[name]; ok {
+		return "", err
+	}
+
+	out, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("%s: %v", name, err)
+		compilerVersions.errs[name] = err
+		logWarn(logFields{"command": command, "error": err}, "Not caching compiles: could not get the compiler version (%v)", err)
+		return "", err
+	}
+	version := strings.TrimSpace(string(out))
+	compilerVersions.versions[name] = version
+	logDebug(logFields{"command": command, "version": version}, "compiler version for the cache: %s", version)
+	return version, nil
+}
+
+// compileWithCache compiles file in dir like lang.Compile, but with
+// --cache-dir set it first looks for an earlier compile of the same sources
+// with the same compiler, and copies in what that produced instead. Compiles
+// that fail aren't kept, so they are always retried.
+func compileWithCache(ctx context.Context, lang Language, dir, file string, cfg *Config) *Result {
+	cc, ok := lang.(cachedCompiler)
+	if cfg.CacheDir == "" || !ok {
+		return lang.Compile(ctx, dir, file)
+	}
+	versionCommand, build := cc.compileSpec()
+	if len(versionCommand) == 0 {
+		return lang.Compile(ctx, dir, file)
+	}
+	version, err := compilerVersion(versionCommand)
+	if err != nil {
+		return lang.Compile(ctx, dir, file)
+	}
+
+	key, err := compileKey(dir, file, version, build)
+	if err != nil {
+		logWarn(logFields{"dir": dir, "error": err}, "Could not hash the sources in %s for the compile cache: %v", dir, err)
+		return lang.Compile(ctx, dir, file)
+	}
+	entry := filepath.Join(cfg.CacheDir, key)
+	if res, ok := restoreCompile(entry, dir); ok {
+		logDebug(logFields{"dir": dir, "key": key}, "reusing cached compile %s for %s", key, dir)
+		return res
+	}
+
+	before, err := listFiles(dir)
+	if err != nil {
+		return lang.Compile(ctx, dir, file)
+	}
+	res := lang.Compile(ctx, dir, file)
+	if res != nil && res.Status != STATUS_COMPILE_ERR {
+		err = storeCompile(cfg.CacheDir, entry, dir, before, res)
+		if err != nil {
+			logWarn(logFields{"dir": dir, "key": key, "error": err}, "Could not add %s to the compile cache: %v", dir, err)
+		}
+	}
+	return res
+}
+
+// compileKey hashes everything that goes into a compile: the compiler
+// version, how it is called, and every file in dir.
+func compileKey(dir, file, version string, build []string) (string, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n%q\n%q\n", version, build, file)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		fmt.Fprintf(h, "%q %d\n", filepath.ToSlash(name), info.Size())
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// restoreCompile copies a cached compile's files into dir. It reports false
+// if there is no such entry, or it couldn't be used.
+func restoreCompile(entry, dir string) (*Result, bool) {
+	data, err := os.ReadFile(filepath.Join(entry, cacheResultFile))
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedCompile
+	err = json.Unmarshal(data, &cached)
+	if err != nil {
+		logWarn(logFields{"entry": entry, "error": err}, "Ignoring broken compile cache entry %s: %v", entry, err)
+		return nil, false
+	}
+
+	start := time.Now()
+	err = copyTree(filepath.Join(entry, cacheFilesDir), dir, nil)
+	if err != nil {
+		logWarn(logFields{"entry": entry, "error": err}, "Could not use compile cache entry %s: %v", entry, err)
+		return nil, false
+	}
+	return &Result{
+		Status:   cached.Status,
+		Duration: time.Since(start),
+		out:      cached.Out,
+		err:      cached.Err,
+	}, true
+}
+
+// storeCompile keeps the files the compile added to dir, i.e. those not in
+// before, as the cache entry. The entry is put together in a temporary
+// folder first, so workers compiling the same sources at once never see
+// half of one.
+func storeCompile(cacheDir, entry, dir string, before map[string]bool, res *Result) error {
+	err := os.MkdirAll(cacheDir, 0777)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(cacheDir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	scratch.add(tmp)
+	defer scratch.remove(tmp)
+
+	err = copyTree(dir, filepath.Join(tmp, cacheFilesDir), before)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cachedCompile{Status: res.Status, Out: res.out, Err: res.err})
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(tmp, cacheResultFile), data, 0666)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, entry)
+	if err != nil {
+		if _, statErr := os.Stat(entry); statErr == nil {
+			// Another worker stored the same compile first
+			return nil
+		}
+	}
+	return err
+}
+
+// copyTree copies the regular files under src into dst, keeping their
+// paths and permissions, and leaving out any listed in skip.
+func copyTree(src, dst string, skip map[string]bool) error {
+	files, err := listFiles(src)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dst, 0777)
+	if err != nil {
+		return err
+	}
+	for name := range files {
+		if skip[name] {
+			continue
+		}
+		from := filepath.Join(src, name)
+		info, err := os.Stat(from)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		to := filepath.Join(dst, name)
+		err = os.MkdirAll(filepath.Dir(to), 0777)
+		if err != nil {
+			return err
+		}
+		_, err = copyFile(from, to)
+		if err != nil {
+			return err
+		}
+		err = os.Chmod(to, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
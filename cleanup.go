@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ScratchPattern names the scratch folder each run makes in the system's
+// temporary folder, which everything it compiles and runs goes into.
+const ScratchPattern = "submissioncheck-*"
+
+// scratchOwnerFile holds the process ID of the run a scratch folder belongs
+// to, so clean can tell a folder still in use from one left behind.
+const scratchOwnerFile = ".owner"
+
+// scratchRegistry keeps track of the scratch folders made so far, so they
+// are removed however the run ends: normally, on an error, or in a panic on
+// any goroutine that defers sweepOnPanic. Only a run killed outright leaves
+// them behind, and clean removes those later.
+type scratchRegistry struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+var scratch = &scratchRegistry{}
+
+func (r *scratchRegistry) add(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paths = append(r.paths, path)
+}
+
+// remove deletes path and everything in it, and forgets about it.
+func (r *scratchRegistry) remove(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.paths {
+		if p == path {
+			r.paths = append(r.paths[:i], r.paths[i+1:]...)
+			break
+		}
+	}
+	return os.RemoveAll(path)
+}
+
+// sweep deletes every folder still registered, newest first.
+func (r *scratchRegistry) sweep() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.paths) - 1; i >= 0; i-- {
+		err := os.RemoveAll(r.paths[i])
+		if err != nil {
+			logWarn(logFields{"path": r.paths[i], "error": err}, "could not remove %s: %v", r.paths[i], err)
+		}
+	}
+	r.paths = nil
+}
+
+// sweepOnPanic sweeps before letting a panic carry on. It has to be
+// deferred directly.
+func (r *scratchRegistry) sweepOnPanic() {
+	if v := recover(); v != nil {
+		r.sweep()
+		panic(v)
+	}
+}
+
+// makeScratchDir makes this run's scratch folder and registers it.
+func makeScratchDir() (string, error) {
+	dir, err := os.MkdirTemp("", ScratchPattern)
+	if err != nil {
+		return "", err
+	}
+	scratch.add(dir)
+	err = os.WriteFile(filepath.Join(dir, scratchOwnerFile), []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	if err != nil {
+		scratch.remove(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// scratchInUse reports whether the run that made the scratch folder dir is
+// still going.
+func scratchInUse(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, scratchOwnerFile))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+	// Signal 0 only checks that the process exists; EPERM means it does,
+	// but belongs to someone else
+	err = syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// cleanLeftovers removes what aborted runs left behind: their scratch
+// folders, with the test folders and compiled classes inside, and any
+// half-stored entries in --cache-dir. Scratch folders of runs still going
+// are left alone.
+func cleanLeftovers(cfg *Config) error {
+	leftovers := make([]string, 0)
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), ScratchPattern))
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if scratchInUse(dir) {
+			logInfo(logFields{"path": dir}, "Skipping %s, its run is still going", dir)
+			continue
+		}
+		leftovers = append(leftovers, dir)
+	}
+	if cfg.CacheDir != "" {
+		tmps, err := filepath.Glob(filepath.Join(cfg.CacheDir, ".tmp-*"))
+		if err != nil {
+			return err
+		}
+		leftovers = append(leftovers, tmps...)
+	}
+
+	removed := 0
+	for _, path := range leftovers {
+		err = os.RemoveAll(path)
+		if err != nil {
+			logWarn(logFields{"path": path, "error": err}, "could not remove %s: %v", path, err)
+			continue
+		}
+		logInfo(logFields{"path": path}, "Removed %s", path)
+		removed++
+	}
+	logInfo(logFields{"removed": removed}, "Removed %d leftover folder(s).", removed)
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Comparators accepted by --comparator and caseComparators in the config
+const (
+	CompareExact      = "exact"
+	CompareWhitespace = "whitespace"
+	CompareFloat      = "float"
+	CompareRegex      = "regex"
+)
+
+// DefaultFloatEpsilon is the tolerance of the float comparator when neither
+// --float-epsilon nor --sig-figs is set.
+const DefaultFloatEpsilon = 1e-6
+
+// Comparator decides whether a program's output matches the expected output.
+// It is given both after the usual clean-up (carriage returns, whitespace and
+// --compare-last-lines). diff describes a mismatch for the report, or why a
+// match wasn't exact; the diff itself is worked out separately, for display.
+type Comparator interface {
+	Compare(expected, actual string) (match bool, diff string)
+}
+
+// ExactComparator wants the outputs to be identical.
+type ExactComparator struct{}
+
+func (ExactComparator) Compare(expected, actual string) (bool, string) {
+	return expected == actual, ""
+}
+
+// NormalizedWhitespaceComparator only cares about the words on each line,
+// ignoring how much whitespace is between them and any blank lines.
+type NormalizedWhitespaceComparator struct{}
+
+func (NormalizedWhitespaceComparator) Compare(expected, actual string) (bool, string) {
+	match := collapseWhitespace(expected) == collapseWhitespace(actual)
+	if match && expected != actual {
+		return true, "output only matched when ignoring whitespace"
+	}
+	return match, ""
+}
+
+// FloatEpsilonComparator compares output token by token (split on any
+// whitespace). Tokens that parse as numbers on both sides match if they
+// agree to SigFigs significant figures, or are within Epsilon of each other,
+// either absolutely or relative to the bigger one; everything else must
+// match exactly.
+type FloatEpsilonComparator struct {
+	Epsilon float64
+	SigFigs int
+}
+
+func (c FloatEpsilonComparator) Compare(expected, actual string) (bool, string) {
+	mismatch := c.tokensMatch(expected, actual)
+	if mismatch != "" {
+		return false, mismatch
+	}
+	if expected != actual {
+		return true, "output only matched within numeric tolerance"
+	}
+	return true, ""
+}
+
+// collapseWhitespace puts single spaces between the words on each line and
+// drops blank lines.
+func collapseWhitespace(s string) string {
+	lines := strings.Split(s, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if words := strings.Fields(line); len(words) > 0 {
+			kept = append(kept, strings.Join(words, " "))
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// token is a whitespace-separated word of output and the line it is on.
+type token struct {
+	text string
+	line int
+}
+
+func tokenize(s string) []token {
+	tokens := make([]token, 0)
+	for i, line := range strings.Split(s, "\n") {
+		for _, f := range strings.Fields(line) {
+			tokens = append(tokens, token{f, i + 1})
+		}
+	}
+	return tokens
+}
+
+// tokensMatch describes the first token that doesn't match, or returns "" if
+// they all do.
+func (c FloatEpsilonComparator) tokensMatch(expected, actual string) string {
+	expTokens := tokenize(expected)
+	actTokens := tokenize(actual)
+
+	for i := range expTokens {
+		exp := expTokens[i]
+		if i >= len(actTokens) {
+			return fmt.Sprintf("output ended early: expected %d tokens, got %d (next expected %q on line %d)",
+				len(expTokens), len(actTokens), exp.text, exp.line)
+		}
+		act := actTokens[i]
+		if exp.text == act.text {
+			continue
+		}
+
+		expNum, expErr := strconv.ParseFloat(exp.text, 64)
+		actNum, actErr := strconv.ParseFloat(act.text, 64)
+		if expErr != nil || actErr != nil || !c.numbersMatch(expNum, actNum) {
+			return fmt.Sprintf("first mismatch at token %d (line %d): expected %q, got %q", i+1, act.line, exp.text, act.text)
+		}
+	}
+	if len(actTokens) > len(expTokens) {
+		extra := actTokens[len(expTokens)]
+		return fmt.Sprintf("too much output: expected %d tokens, got %d (first extra %q on line %d)",
+			len(expTokens), len(actTokens), extra.text, extra.line)
+	}
+	return ""
+}
+
+func (c FloatEpsilonComparator) numbersMatch(expected, actual float64) bool {
+	if c.SigFigs > 0 && roundSigFigs(expected, c.SigFigs) == roundSigFigs(actual, c.SigFigs) {
+		return true
+	}
+	if c.Epsilon > 0 {
+		d := math.Abs(expected - actual)
+		return d <= c.Epsilon || d <= c.Epsilon*math.Max(math.Abs(expected), math.Abs(actual))
+	}
+	return false
+}
+
+func roundSigFigs(f float64, n int) string {
+	return strconv.FormatFloat(f, 'e', n-1, 64)
+}
+
+// RegexComparator compares output line by line, with expected lines as
+// regular expressions that the whole actual line must match. With Marked,
+// only lines starting with RegexPrefix are patterns, and the rest are
+// compared with Lines.
+type RegexComparator struct {
+	Marked bool
+	Lines  Comparator
+}
+
+func (c RegexComparator) Compare(expected, actual string) (bool, string) {
+	expLines := strings.Split(expected, "\n")
+	actLines := strings.Split(actual, "\n")
+	if len(expLines) != len(actLines) {
+		return false, fmt.Sprintf("expected %d lines of output, got %d", len(expLines), len(actLines))
+	}
+
+	for i, exp := range expLines {
+		act := actLines[i]
+		if strings.HasPrefix(exp, RegexPrefix) || !c.Marked {
+			pattern := strings.TrimPrefix(exp, RegexPrefix)
+			if _, err := regexp.Compile(pattern); err != nil {
+				return false, fmt.Sprintf("line %d: invalid pattern /%s/ in expected output: %v", i+1, pattern, err)
+			}
+			re := regexp.MustCompile("^(?:" + pattern + ")$")
+			if !re.MatchString(act) {
+				return false, fmt.Sprintf("line %d does not match pattern /%s/: got %q", i+1, pattern, act)
+			}
+			continue
+		}
+
+		if exp == act {
+			continue
+		}
+		if c.Lines != nil {
+			if match, _ := c.Lines.Compare(exp, act); match {
+				continue
+			}
+		}
+		return false, fmt.Sprintf("line %d: expected %q, got %q", i+1, exp, act)
+	}
+	return true, ""
+}
+
+func hasRegexLines(expected string) bool {
+	for _, line := range strings.Split(expected, "\n") {
+		if strings.HasPrefix(line, RegexPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func validComparator(name string) error {
+	switch name {
+	case "", CompareExact, CompareWhitespace, CompareFloat, CompareRegex:
+		return nil
+	}
+	return fmt.Errorf("unknown comparator %q (want %s, %s, %s or %s)", name, CompareExact, CompareWhitespace, CompareFloat, CompareRegex)
+}
+
+// comparator picks how to compare output against expected: the named
+// comparator if there is one, and otherwise by what was asked for on the
+// command line and the expected output itself. Lines marked with
+// RegexPrefix are patterns, and with --float-epsilon or --sig-figs numbers
+// only have to be close.
+func (cfg *Config) comparator(name, expected string) Comparator {
+	numeric := FloatEpsilonComparator{Epsilon: cfg.FloatEpsilon, SigFigs: cfg.SigFigs}
+	tolerant := numeric.Epsilon > 0 || numeric.SigFigs > 0
+
+	switch name {
+	case CompareExact:
+		return ExactComparator{}
+	case CompareWhitespace:
+		return NormalizedWhitespaceComparator{}
+	case CompareFloat:
+		if !tolerant {
+			numeric.Epsilon = DefaultFloatEpsilon
+		}
+		return numeric
+	case CompareRegex:
+		return RegexComparator{}
+	}
+
+	var lines Comparator
+	if tolerant {
+		lines = numeric
+	}
+	if hasRegexLines(expected) {
+		return RegexComparator{Marked: true, Lines: lines}
+	}
+	if lines != nil {
+		return lines
+	}
+	return ExactComparator{}
+}
+
+// caseComparator is the comparator chosen for the case with expected output
+// outFile: its entry in caseComparators, or --comparator.
+func (cfg *Config) caseComparator(outFile string) string {
+	if name, ok := cfg.CaseComparators[caseName(outFile)]; ok {
+		return name
+	}
+	return cfg.Comparator
+}
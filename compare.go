@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	FastRejectRatio    = 2
+	FastRejectMinBytes = 4096
+)
+
+// Diff modes accepted by --diff-mode. Without one, files get plain diffs
+// and a terminal gets inline ones.
+const (
+	DiffPlain      = "plain"
+	DiffInline     = "inline"
+	DiffSideBySide = "sidebyside"
+	DiffUnified    = "unified"
+)
+
+// diffMode is the diff mode to render with: --diff-mode, or the colored
+// inline diff on a terminal that takes colors and a plain one anywhere else.
+func (cfg *Config) diffMode(terminal bool) string {
+	if cfg.DiffMode != "" {
+		return cfg.DiffMode
+	}
+	if terminal && useColor() {
+		return DiffInline
+	}
+	return DiffPlain
+}
+
+// RegexPrefix marks a line of expected output as a regular expression that
+// the whole output line must match, for output that can't be known exactly.
+const RegexPrefix = "REGEX:"
+
+// compareOutput checks a program's actual output against the expected output
+// with the named comparator (see Config.comparator), and returns whether they
+// match along with the diff between them, and a note for the report if the
+// match wasn't exact or the diff was skipped.
+func compareOutput(expected, actual, comparator string, cfg *Config) (match bool, diffs []diffmatchpatch.Diff, note string) {
+	expected = strings.ReplaceAll(expected, "\r", "")
+	if cfg.NormalizeWhitespace {
+		expected = normalizeWhitespace(expected)
+		actual = normalizeWhitespace(actual)
+	} else if !cfg.StrictWhitespace {
+		expected = trimTrailingWhitespace(expected)
+		actual = trimTrailingWhitespace(actual)
+	}
+	if cfg.TrimTrailingNewline {
+		expected = strings.TrimSuffix(expected, "\n")
+		actual = strings.TrimSuffix(actual, "\n")
+	}
+	if cfg.CompareLastLines > 0 {
+		expected = lastLines(expected, cfg.CompareLastLines)
+		actual = lastLines(actual, cfg.CompareLastLines)
+	}
+
+	// DiffMain is O(n*m), so don't bother with it for hugely mismatched output
+	if cfg.FastReject {
+		if reason, ok := sizeMismatch(expected, actual); ok {
+			return false, nil, reason
+		}
+	}
+
+	// Compare the outputs themselves; the diff is only for display
+	match, note = cfg.comparator(comparator, expected).Compare(expected, actual)
+	if !match || note != "" {
+		diffs = diffmatchpatch.New().DiffMain(expected, actual, false)
+	}
+	return match, diffs, note
+}
+
+// prettyDiff renders a diff inline, character by character, in color.
+func prettyDiff(diffs []diffmatchpatch.Diff) string {
+	return diffmatchpatch.New().DiffPrettyText(diffs)
+}
+
+// renderDiff renders a diff for the terminal and text reports in one of the
+// --diff-mode modes.
+func renderDiff(diffs []diffmatchpatch.Diff, mode string) string {
+	switch mode {
+	case DiffSideBySide:
+		return sideBySideDiff(lineDiff(diffs))
+	case DiffUnified:
+		return unifiedDiff(lineDiff(diffs))
+	case DiffInline:
+		return prettyDiff(diffs)
+	}
+	return plainDiff(lineDiff(diffs))
+}
+
+// plainDiff lists every line of the diff, prefixed with - if only the
+// expected output has it, + if only the actual output does, or a space.
+func plainDiff(lines []diffLine) string {
+	sb := &strings.Builder{}
+	for _, l := range lines {
+		switch l.op {
+		case diffmatchpatch.DiffDelete:
+			sb.WriteString("-")
+		case diffmatchpatch.DiffInsert:
+			sb.WriteString("+")
+		default:
+			sb.WriteString(" ")
+		}
+		sb.WriteString(l.text + "\n")
+	}
+	return sb.String()
+}
+
+// diffLine is one line of a line-by-line diff.
+type diffLine struct {
+	op   diffmatchpatch.Operation
+	text string
+}
+
+// lineDiff diffs the two sides of a character diff again, a whole line at a
+// time, for the line-based diff modes. Each distinct line is diffed as a
+// single rune.
+func lineDiff(diffs []diffmatchpatch.Diff) []diffLine {
+	dmp := diffmatchpatch.New()
+	index := make(map[string]rune)
+	lineArray := make([]string, 0)
+	encode := func(text string) []rune {
+		runes := make([]rune, 0)
+		for _, line := range strings.SplitAfter(text, "\n") {
+			if line == "" {
+				continue
+			}
+			r, ok := index[line]
+			if !ok {
+				// Skip the surrogates, which don't survive being a string
+				r = rune(len(lineArray))
+				if r >= 0xD800 {
+					r += 0x800
+				}
+				index[line] = r
+				lineArray = append(lineArray, line)
+			}
+			runes = append(runes, r)
+		}
+		return runes
+	}
+	a := encode(dmp.DiffText1(diffs))
+	b := encode(dmp.DiffText2(diffs))
+
+	lines := make([]diffLine, 0)
+	for _, d := range dmp.DiffMainRunes(a, b, false) {
+		for _, r := range d.Text {
+			if r >= 0xE000 {
+				r -= 0x800
+			}
+			lines = append(lines, diffLine{d.Type, strings.TrimSuffix(lineArray[r], "\n")})
+		}
+	}
+	return lines
+}
+
+// SideBySideMaxWidth caps how wide the expected column of a side-by-side diff
+// is padded to.
+const SideBySideMaxWidth = 60
+
+// sideBySideDiff puts the expected and actual output in two columns, marking
+// changed lines with |, missing ones with < and extra ones with >.
+func sideBySideDiff(lines []diffLine) string {
+	width := len("Expected")
+	for _, l := range lines {
+		if l.op != diffmatchpatch.DiffInsert && len(l.text) > width {
+			width = len(l.text)
+		}
+	}
+	if width > SideBySideMaxWidth {
+		width = SideBySideMaxWidth
+	}
+
+	sb := &strings.Builder{}
+	row := func(left, mark, right string) {
+		sb.WriteString(strings.TrimRight(fmt.Sprintf("%-*s %s %s", width, left, mark, right), " ") + "\n")
+	}
+	row("Expected", " ", "Actual")
+
+	// Removed lines are paired up with the added lines that replace them
+	var removed, added []string
+	flush := func() {
+		for i := 0; i < len(removed) || i < len(added); i++ {
+			switch {
+			case i < len(removed) && i < len(added):
+				row(removed[i], "|", added[i])
+			case i < len(removed):
+				row(removed[i], "<", "")
+			default:
+				row("", ">", added[i])
+			}
+		}
+		removed, added = nil, nil
+	}
+	for _, l := range lines {
+		switch l.op {
+		case diffmatchpatch.DiffDelete:
+			removed = append(removed, l.text)
+		case diffmatchpatch.DiffInsert:
+			added = append(added, l.text)
+		default:
+			flush()
+			row(l.text, " ", l.text)
+		}
+	}
+	flush()
+	return sb.String()
+}
+
+// UnifiedContext is how many unchanged lines a unified diff shows around
+// each change.
+const UnifiedContext = 3
+
+// unifiedDiff renders a standard unified diff of the expected output against
+// the actual output.
+func unifiedDiff(lines []diffLine) string {
+	sb := &strings.Builder{}
+	sb.WriteString("--- expected\n+++ actual\n")
+
+	for start := 0; start < len(lines); {
+		// Find the next change, then stretch the hunk over every change that
+		// is within two contexts' length of the one before it
+		first := start
+		for first < len(lines) && lines[first].op == diffmatchpatch.DiffEqual {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for i := first; i < len(lines) && i-last <= 2*UnifiedContext; i++ {
+			if lines[i].op != diffmatchpatch.DiffEqual {
+				last = i
+			}
+		}
+		from := first - UnifiedContext
+		if from < start {
+			from = start
+		}
+		to := last + UnifiedContext + 1
+		if to > len(lines) {
+			to = len(lines)
+		}
+
+		oldLine, newLine := 1, 1
+		for _, l := range lines[:from] {
+			if l.op != diffmatchpatch.DiffInsert {
+				oldLine++
+			}
+			if l.op != diffmatchpatch.DiffDelete {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		body := &strings.Builder{}
+		for _, l := range lines[from:to] {
+			switch l.op {
+			case diffmatchpatch.DiffDelete:
+				oldCount++
+				body.WriteString("-" + l.text + "\n")
+			case diffmatchpatch.DiffInsert:
+				newCount++
+				body.WriteString("+" + l.text + "\n")
+			default:
+				oldCount++
+				newCount++
+				body.WriteString(" " + l.text + "\n")
+			}
+		}
+		// An empty side starts at the line before, as diff -u does
+		if oldCount == 0 {
+			oldLine--
+		}
+		if newCount == 0 {
+			newLine--
+		}
+		sb.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount))
+		sb.WriteString(body.String())
+		start = to
+	}
+	return sb.String()
+}
+
+// sizeMismatch reports whether expected and actual differ so much in size
+// that they can't match: the bigger one is at least FastRejectRatio times
+// the smaller in lines or bytes, and over FastRejectMinBytes (small outputs
+// are cheap to diff anyway).
+func sizeMismatch(expected, actual string) (string, bool) {
+	expLines := strings.Count(expected, "\n")
+	actLines := strings.Count(actual, "\n")
+	wild := func(a, b int) bool {
+		if a < b {
+			a, b = b, a
+		}
+		return a >= FastRejectRatio*b && a > 0
+	}
+
+	big := len(expected)
+	if len(actual) > big {
+		big = len(actual)
+	}
+	if big <= FastRejectMinBytes || !(wild(expLines, actLines) || wild(len(expected), len(actual))) {
+		return "", false
+	}
+	return fmt.Sprintf("output size mismatch (expected %d lines / %d bytes, got %d lines / %d bytes), full diff skipped",
+		expLines, len(expected), actLines, len(actual)), true
+}
+
+// runDiff compares two files the same way a graded test case would be
+// compared, and prints the verdict and diff.
+func runDiff(expectedPath, actualPath string, cfg *Config) error {
+	expected, err := readTestFile(expectedPath)
+	if err != nil {
+		return err
+	}
+	actual, err := readTestFile(actualPath)
+	if err != nil {
+		return err
+	}
+
+	formatErr := checkFormat(string(expected), string(actual), cfg)
+	if formatErr != nil {
+		fmt.Printf("Output format invalid: %s\n", formatErr)
+		fmt.Println("\nVerdict: MISMATCH")
+		return cli.Exit("", 1)
+	}
+
+	match, diffs, note := compareOutput(string(expected), string(actual), cfg.Comparator, cfg)
+	diff := renderDiff(diffs, cfg.diffMode(true))
+	if note != "" {
+		fmt.Printf("NOTE: %s\n", note)
+	}
+	if match {
+		fmt.Println("Verdict: MATCH")
+		return nil
+	}
+
+	fmt.Print("Diff Log:\n\n")
+	if !cfg.Verbose {
+		fmt.Print(truncLines(diff, VerboseNumLines))
+	} else {
+		fmt.Print(diff)
+	}
+	fmt.Println("\nVerdict: MISMATCH")
+	return cli.Exit("", 1)
+}
+
+// normalizeWhitespace drops carriage returns and trailing whitespace on every
+// line, and collapses runs of blank lines into one.
+func normalizeWhitespace(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r", ""), "\n")
+	kept := make([]string, 0, len(lines))
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t\f\v")
+		if line == "" && i > 0 && kept[len(kept)-1] == "" {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// trimTrailingWhitespace drops trailing whitespace on every line, and any
+// blank lines at the end, leaving a single final newline if there was one.
+func trimTrailingWhitespace(s string) string {
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t\f\v")
+	}
+	trimmed := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if trimmed != "" && strings.HasSuffix(s, "\n") {
+		trimmed += "\n"
+	}
+	return trimmed
+}
+
+// lastLines returns the final n lines of s, keeping a trailing newline if s
+// had one.
+func lastLines(s string, n int) string {
+	trailing := strings.HasSuffix(s, "\n")
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	ret := strings.Join(lines, "\n")
+	if trailing {
+		ret += "\n"
+	}
+	return ret
+}
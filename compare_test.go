@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestCompareOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+		actual   string
+		match    bool
+	}{
+		{"identical", nil, "1 2\n3\n", "1 2\n3\n", true},
+		{"different", nil, "1 2\n3\n", "1 2\n4\n", false},
+		{"carriage returns in expected", nil, "1 2\r\n3\r\n", "1 2\n3\n", true},
+		{"trailing whitespace", nil, "1 2\n3\n", "1 2  \n3\t\n", true},
+		{"trailing whitespace, strict", []string{"--strict-whitespace"}, "1 2\n3\n", "1 2  \n3\n", false},
+		{"missing final newline", nil, "1 2\n3\n", "1 2\n3", false},
+		{"missing final newline, trimmed", []string{"--trim-trailing-newline"}, "1 2\n3\n", "1 2\n3", true},
+		{"extra final newline, trimmed", []string{"--trim-trailing-newline"}, "1 2\n3", "1 2\n3\n", true},
+		{"last lines match", []string{"--compare-last-lines", "2"}, "2\n3\n", "Enter numbers: 1\n2\n3\n", true},
+		{"last lines differ", []string{"--compare-last-lines", "2"}, "2\n3\n", "Enter numbers: 1\n2\n4\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, tt.args...)
+			match, diffs, note := compareOutput(tt.expected, tt.actual, "", cfg)
+			if match != tt.match {
+				t.Errorf("match = %v, want %v", match, tt.match)
+			}
+			if match && note == "" && len(diffs) != 0 {
+				t.Errorf("exact match gave %d diffs, want none", len(diffs))
+			}
+			if !match && len(diffs) == 0 {
+				t.Errorf("mismatch gave no diffs")
+			}
+		})
+	}
+}
@@ -0,0 +1,842 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileNames are looked for in the target directory when no --config
+// is given, in this order.
+var ConfigFileNames = []string{"grader.yaml", "grader.yml", "grader.json"}
+
+// Config holds the options for a single grading run. It can be loaded from a
+// YAML or JSON file committed next to the assignment, with any command line
+// flags layered on top.
+type Config struct {
+	TargetDir      string `yaml:"targetDir" json:"targetDir"`
+	SubmissionsDir string `yaml:"submissionsDir" json:"submissionsDir"`
+	TestsDir       string `yaml:"testsDir" json:"testsDir"`
+
+	Timeout        int    `yaml:"timeout" json:"timeout"`
+	MaxOutputBytes int64  `yaml:"maxOutputBytes" json:"maxOutputBytes"`
+	MaxMemory      string `yaml:"maxMemory" json:"maxMemory"`
+	MaxMemoryMB    int    `yaml:"maxMemoryMB" json:"maxMemoryMB"`
+	Verbose        bool   `yaml:"verbose" json:"verbose"`
+	MaxDepth       int    `yaml:"maxDepth" json:"maxDepth"`
+	Seed           int64  `yaml:"seed" json:"seed"`
+	Workers        int    `yaml:"workers" json:"workers"`
+	ReportWorkers  int    `yaml:"reportWorkers" json:"reportWorkers"`
+
+	Language     string             `yaml:"language" json:"language"`
+	Languages    []*CommandLanguage `yaml:"languages" json:"languages"`
+	JVMFlags     []string           `yaml:"jvmFlags" json:"jvmFlags"`
+	Naming       string             `yaml:"naming" json:"naming"`
+	Driver       string             `yaml:"driver" json:"driver"`
+	Reference    string             `yaml:"reference" json:"reference"`
+	RetryEmpty   bool               `yaml:"retryEmpty" json:"retryEmpty"`
+	Retries      int                `yaml:"retries" json:"retries"`
+	VerifyClean  bool               `yaml:"verifyClean" json:"verifyClean"`
+	Incremental  bool               `yaml:"incremental" json:"incremental"`
+	Force        bool               `yaml:"force" json:"force"`
+	Only         []string           `yaml:"only" json:"only"`
+	Skip         []string           `yaml:"skip" json:"skip"`
+	Watch        bool               `yaml:"watch" json:"watch"`
+	DryRun       bool               `yaml:"dryRun" json:"dryRun"`
+	Quiet        bool               `yaml:"quiet" json:"quiet"`
+	CacheDir     string             `yaml:"cacheDir" json:"cacheDir"`
+	Deadline     string             `yaml:"deadline" json:"deadline"`
+	Serve        string             `yaml:"serve" json:"serve"`
+	Sandbox      string             `yaml:"sandbox" json:"sandbox"`
+	SandboxImage string             `yaml:"sandboxImage" json:"sandboxImage"`
+	SandboxUser  string             `yaml:"sandboxUser" json:"sandboxUser"`
+
+	CompareLastLines    int     `yaml:"compareLastLines" json:"compareLastLines"`
+	SigFigs             int     `yaml:"sigFigs" json:"sigFigs"`
+	FloatEpsilon        float64 `yaml:"floatEpsilon" json:"floatEpsilon"`
+	Schema              string  `yaml:"schema" json:"schema"`
+	FastReject          bool    `yaml:"fastReject" json:"fastReject"`
+	NormalizeWhitespace bool    `yaml:"normalizeWhitespace" json:"normalizeWhitespace"`
+	StrictWhitespace    bool    `yaml:"strictWhitespace" json:"strictWhitespace"`
+	TrimTrailingNewline bool    `yaml:"trimTrailingNewline" json:"trimTrailingNewline"`
+	DiffMode            string  `yaml:"diffMode" json:"diffMode"`
+	PartialCredit       bool    `yaml:"partialCredit" json:"partialCredit"`
+	Comparator          string  `yaml:"comparator" json:"comparator"`
+
+	// CaseComparators overrides Comparator for single test cases, by name
+	CaseComparators map[string]string `yaml:"caseComparators" json:"caseComparators"`
+
+	Due         string  `yaml:"due" json:"due"`
+	LatePenalty float64 `yaml:"latePenalty" json:"latePenalty"`
+
+	Rubric           string `yaml:"rubric" json:"rubric"`
+	Format           string `yaml:"format" json:"format"`
+	MaxReportBytes   int64  `yaml:"maxReportBytes" json:"maxReportBytes"`
+	Histogram        bool   `yaml:"histogram" json:"histogram"`
+	HistogramBuckets int    `yaml:"histogramBuckets" json:"histogramBuckets"`
+	IncludeSource    bool   `yaml:"includeSource" json:"includeSource"`
+
+	LogFormat   string `yaml:"logFormat" json:"logFormat"`
+	LogLevel    string `yaml:"logLevel" json:"logLevel"`
+	MetricsAddr string `yaml:"metricsAddr" json:"metricsAddr"`
+	DB          string `yaml:"db" json:"db"`
+
+	due       time.Time
+	deadline  time.Duration
+	maxMemory int64
+	schema    *Schema
+	rubric    []*RubricCriterion
+	langs     map[string]Language
+	formats   map[string]bool
+	exts      map[string]string
+	executor  Executor
+}
+
+// configFlag ties a command line flag to the Config field it sets.
+type configFlag struct {
+	flag  cli.Flag
+	apply func(cfg *Config, c *cli.Context)
+}
+
+var configFlags = []configFlag{
+	{
+		&cli.StringFlag{
+			Name:     "path",
+			Aliases:  []string{"p", "target-dir", "target"},
+			Usage:    "path to project folder that contains submissions / testcases",
+			Required: false,
+			Value:    DefaultTargetDir,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.TargetDir = c.String("path") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "submissions",
+			Usage:    "folder with the raw submissions, relative to the target directory",
+			Required: false,
+			Value:    "submissions",
+		},
+		func(cfg *Config, c *cli.Context) { cfg.SubmissionsDir = c.String("submissions") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "testcases",
+			Usage:    "folder with the .in / .out test cases, relative to the target directory",
+			Required: false,
+			Value:    "testcases",
+		},
+		func(cfg *Config, c *cli.Context) { cfg.TestsDir = c.String("testcases") },
+	},
+	{
+		&cli.IntFlag{
+			Name:     "timeout",
+			Aliases:  []string{"t"},
+			Usage:    "timeout threshold when running tests, in seconds",
+			Required: false,
+			Value:    DefaultTimeout,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Timeout = c.Int("timeout") },
+	},
+	{
+		&cli.Int64Flag{
+			Name:     "max-output-bytes",
+			Usage:    "stop a program once it prints more than this many bytes to stdout or stderr (0 = no limit)",
+			Required: false,
+			Value:    DefaultMaxOutputBytes,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.MaxOutputBytes = c.Int64("max-output-bytes") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "max-memory",
+			Usage:    "memory limit for each run, e.g. 256m or 1g (the heap size for Java, the address space otherwise). Programs that run out are marked MEMORY LIMIT EXCEEDED",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.MaxMemory = c.String("max-memory") },
+	},
+	{
+		&cli.IntFlag{
+			Name:     "max-memory-mb",
+			Usage:    "mark runs whose peak resident memory goes over this many megabytes PEAK MEMORY EXCEEDED, Java included (0 = no limit)",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.MaxMemoryMB = c.Int("max-memory-mb") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "verbose",
+			Aliases:  []string{"v"},
+			Usage:    "print full out/diff logs, even if the output is very large",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Verbose = c.Bool("verbose") },
+	},
+	{
+		&cli.IntFlag{
+			Name:     "max-depth",
+			Aliases:  []string{"d"},
+			Usage:    "how many folders deep to look for submissions inside <submissions> (1 = only files directly inside it, 0 = no limit)",
+			Required: false,
+			Value:    1,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.MaxDepth = c.Int("max-depth") },
+	},
+	{
+		&cli.Int64Flag{
+			Name:     "seed",
+			Usage:    "seed for everything nondeterministic (e.g. test folder names), so two runs give identical reports (0 = pick one and print it)",
+			Required: false,
+			Value:    0,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Seed = c.Int64("seed") },
+	},
+	{
+		&cli.IntFlag{
+			Name:     "workers",
+			Aliases:  []string{"j", "run-workers"},
+			Usage:    "how many submissions to compile and run at once",
+			Required: false,
+			Value:    runtime.NumCPU(),
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Workers = c.Int("workers") },
+	},
+	{
+		&cli.IntFlag{
+			Name:     "report-workers",
+			Usage:    "how many reports to grade and write at once",
+			Required: false,
+			Value:    runtime.NumCPU(),
+		},
+		func(cfg *Config, c *cli.Context) { cfg.ReportWorkers = c.Int("report-workers") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "language",
+			Usage:    "language to grade every submission as (java, kotlin, python, c, cpp, exec to run the file directly, or one from the config), instead of picking by file extension",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Language = c.String("language") },
+	},
+	{
+		&cli.StringSliceFlag{
+			Name:     "jvm-flags",
+			Usage:    "extra flag to pass to every java invocation, e.g. --jvm-flags -Xss4m (repeat for more than one)",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.JVMFlags = c.StringSlice("jvm-flags") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "naming",
+			Usage:    "how Java submission filenames name their class: canvas (<name>_<id>_<id>_<Class>.java) or plain (<Class>.java). Only used when the source doesn't declare a class, and to pick the main class of zipped or folder submissions",
+			Required: false,
+			Value:    NamingCanvas,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Naming = c.String("naming") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "driver",
+			Usage:    "java test driver template to compile and run with each submission instead of its own main. {{class}} in the template is replaced by the submission's class name",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Driver = c.String("driver") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "reference",
+			Usage:    "reference solution (e.g. Reference.java, or a script) used to produce the expected output of <testcases>/*.family test families",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Reference = c.String("reference") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "retry-empty",
+			Usage:    "re-run a case once if it exited successfully but printed nothing, to rule out transient flakes",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.RetryEmpty = c.Bool("retry-empty") },
+	},
+	{
+		&cli.IntFlag{
+			Name:     "retries",
+			Usage:    "re-run a case up to N more times while it times out or fails in a way that looks like the machine's fault (couldn't start, killed from outside, out of resources). Cases that only pass on a retry are noted in the report",
+			Required: false,
+			Value:    0,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Retries = c.Int("retries") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "verify-clean",
+			Usage:    "after testing each submission, check its working directory for files it created and flag them in the report",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.VerifyClean = c.Bool("verify-clean") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "cache-dir",
+			Usage:    "keep compiled submissions in this folder, keyed by their sources and the compiler version, and reuse them instead of compiling the same sources again",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.CacheDir = c.String("cache-dir") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "deadline",
+			Usage:    "stop grading after this long for the whole run, e.g. 20m. Runs still going are killed, submissions not reached are skipped, and reports are written for everything graded so far",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Deadline = c.String("deadline") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "serve",
+			Usage:    "instead of grading the submissions folder, accept submissions POSTed to /submit at this address, e.g. :8080, and serve their JSON reports from /result/<id>",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Serve = c.String("serve") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "sandbox",
+			Usage:    "where submissions run: none, directly on this machine; docker, in a container with no network and limited memory, CPU and processes; or user, as --sandbox-user through sudo",
+			Required: false,
+			Value:    SandboxNone,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Sandbox = c.String("sandbox") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "sandbox-image",
+			Usage:    "docker image to run submissions in with --sandbox docker. It needs whatever the languages graded need to run, e.g. java",
+			Required: false,
+			Value:    "openjdk:17",
+		},
+		func(cfg *Config, c *cli.Context) { cfg.SandboxImage = c.String("sandbox-image") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "sandbox-user",
+			Usage:    "unprivileged user to run submissions as with --sandbox user. sudo must let you run commands as them without a password",
+			Required: false,
+			Value:    "nobody",
+		},
+		func(cfg *Config, c *cli.Context) { cfg.SandboxUser = c.String("sandbox-user") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "incremental",
+			Usage:    "keep existing reports and only grade submissions that are newer than their report (or whose test cases changed since)",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Incremental = c.Bool("incremental") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "force",
+			Usage:    "regrade every submission, even with --incremental",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Force = c.Bool("force") },
+	},
+	{
+		&cli.StringSliceFlag{
+			Name:     "only",
+			Usage:    "only grade submissions whose name, or student under --naming canvas, matches one of these glob patterns, e.g. --only 'smith*,jones_*' (repeat or separate with commas). Reports of the others are kept",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Only = splitPatterns(c.StringSlice("only")) },
+	},
+	{
+		&cli.StringSliceFlag{
+			Name:     "skip",
+			Usage:    "leave out submissions whose name, or student under --naming canvas, matches one of these glob patterns (repeat or separate with commas). Reports of the others are kept",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Skip = splitPatterns(c.StringSlice("skip")) },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "watch",
+			Usage:    "after grading, keep watching the submissions folder and regrade any submission that is added or changed, until stopped with Ctrl-C",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Watch = c.Bool("watch") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "dry-run",
+			Usage:    "list the submissions (with the class each would run as) and test cases that would be graded, without compiling or running anything",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.DryRun = c.Bool("dry-run") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "quiet",
+			Aliases:  []string{"q"},
+			Usage:    "don't log progress and verdicts for each submission, only warnings, errors and the summary at the end",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Quiet = c.Bool("quiet") },
+	},
+	{
+		&cli.IntFlag{
+			Name:     "compare-last-lines",
+			Usage:    "only compare the last N lines of the expected and actual output (0 = compare everything)",
+			Required: false,
+			Value:    0,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.CompareLastLines = c.Int("compare-last-lines") },
+	},
+	{
+		&cli.IntFlag{
+			Name:     "sig-figs",
+			Usage:    "compare output token by token, treating numbers as equal if they agree to N significant figures (0 = exact comparison)",
+			Required: false,
+			Value:    0,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.SigFigs = c.Int("sig-figs") },
+	},
+	{
+		&cli.Float64Flag{
+			Name:     "float-tol",
+			Aliases:  []string{"float-epsilon"},
+			Usage:    "compare output token by token, treating numbers as equal if they are within this absolute or relative tolerance, e.g. 1e-6 (0 = exact comparison)",
+			Required: false,
+			Value:    0,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.FloatEpsilon = c.Float64("float-tol") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "schema",
+			Usage:    "check output format before diffing, e.g. \"lines=expected,tokens=1,each=int\" (rules: lines=<n>|expected, tokens=<n>, each=int|float|word)",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Schema = c.String("schema") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "normalize-whitespace",
+			Usage:    "ignore carriage returns, trailing spaces on each line and repeated blank lines when comparing output",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.NormalizeWhitespace = c.Bool("normalize-whitespace") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "strict-whitespace",
+			Usage:    "require trailing spaces and blank lines at the end of the output to match exactly (by default they are ignored)",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.StrictWhitespace = c.Bool("strict-whitespace") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "trim-trailing-newline",
+			Usage:    "strip one newline from the end of the expected and actual output before comparing, so a missing or extra final newline doesn't count; nothing else about whitespace changes",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.TrimTrailingNewline = c.Bool("trim-trailing-newline") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "diff-mode",
+			Usage:    "how diffs are shown: plain (every line, marked - or +), inline (colored, character by character), sidebyside (expected and actual in two columns) or unified (+/- lines, as diff -u). By default reports get plain diffs and the diff command colors them on a terminal",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.DiffMode = c.String("diff-mode") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "comparator",
+			Usage:    "how output is checked against the expected output: exact, whitespace (only the words on each line count), float (numbers within --float-tol or --sig-figs, 1e-6 by default) or regex (every expected line is a pattern). By default lines starting with REGEX: are patterns, and numbers get the tolerance of --float-tol or --sig-figs if set",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Comparator = c.String("comparator") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "fast-reject",
+			Usage:    "skip the (slow) full diff when the output is wildly bigger or smaller than expected, and just report the size mismatch",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.FastReject = c.Bool("fast-reject") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "partial-credit",
+			Usage:    "score submissions by the share of cases passed. Set --partial-credit=false to give 0% unless every case passes",
+			Required: false,
+			Value:    true,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.PartialCredit = c.Bool("partial-credit") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "due",
+			Usage:    "assignment deadline (\"2006-01-02 15:04\" local time, or RFC3339). Submissions with a later <file>.meta submitted= time lose --late-penalty",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Due = c.String("due") },
+	},
+	{
+		&cli.Float64Flag{
+			Name:     "late-penalty",
+			Usage:    "percent of the score taken off per started day past --due",
+			Required: false,
+			Value:    10,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.LatePenalty = c.Float64("late-penalty") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "rubric",
+			Usage:    "JSON file mapping test cases to rubric criteria. Reports are organized by criterion instead of by test case",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Rubric = c.String("rubric") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "format",
+			Usage:    "comma-separated report formats: \"text\" (<name>.txt), \"json\" (<name>.json), \"html\" (<name>.html and index.html), or \"both\" for text and JSON",
+			Required: false,
+			Value:    FormatText,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Format = c.String("format") },
+	},
+	{
+		&cli.Int64Flag{
+			Name:     "max-report-bytes",
+			Usage:    "total size budget for all reports, in bytes (0 = unlimited). Once used up, remaining reports only list pass/fail results",
+			Required: false,
+			Value:    0,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.MaxReportBytes = c.Int64("max-report-bytes") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "include-source",
+			Usage:    "add the submitted source files to the end of each text and HTML report, highlighted in HTML",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.IncludeSource = c.Bool("include-source") },
+	},
+	{
+		&cli.BoolFlag{
+			Name:     "histogram",
+			Usage:    "print a histogram of submission scores and save it to <reports>/histogram.txt / histogram.csv",
+			Required: false,
+			Value:    false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.Histogram = c.Bool("histogram") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "log-format",
+			Usage:    "format of the progress log: text, or json for one JSON object per line",
+			Required: false,
+			Value:    LogFormatText,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.LogFormat = c.String("log-format") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "log-level",
+			Usage:    "least important progress messages to print: debug, info, warn or error",
+			Required: false,
+			Value:    "info",
+		},
+		func(cfg *Config, c *cli.Context) { cfg.LogLevel = c.String("log-level") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "metrics-addr",
+			Usage:    "serve Prometheus metrics about the run at this address, e.g. :9090, under /metrics. Most useful with --watch",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.MetricsAddr = c.String("metrics-addr") },
+	},
+	{
+		&cli.StringFlag{
+			Name:     "db",
+			Usage:    "also save every graded submission and its case results to this SQLite database, created if needed, to query past runs",
+			Required: false,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.DB = c.String("db") },
+	},
+	{
+		&cli.IntFlag{
+			Name:     "histogram-buckets",
+			Usage:    "number of equal-width score buckets between 0% and 100% to use for --histogram",
+			Required: false,
+			Value:    10,
+		},
+		func(cfg *Config, c *cli.Context) { cfg.HistogramBuckets = c.Int("histogram-buckets") },
+	},
+}
+
+func appFlags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:     "config",
+			Aliases:  []string{"c"},
+			Usage:    "YAML or JSON grading config. Defaults to grader.yaml / grader.yml / grader.json in the target directory, if there is one",
+			Required: false,
+		},
+	}
+	for _, f := range configFlags {
+		flags = append(flags, f.flag)
+	}
+	return flags
+}
+
+// configFromContext builds the run configuration: flag defaults first, then
+// the config file (if any), then any flags given explicitly on the command
+// line.
+func configFromContext(c *cli.Context) (*Config, error) {
+	cfg := &Config{}
+	for _, f := range configFlags {
+		f.apply(cfg, c)
+	}
+
+	path := c.String("config")
+	if path == "" {
+		path = findConfigFile(cfg.TargetDir)
+	}
+	if path != "" {
+		err := loadConfigFile(path, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range configFlags {
+		if c.IsSet(f.flag.Names()[0]) {
+			f.apply(cfg, c)
+		}
+	}
+
+	err := cfg.finish()
+	if err == nil && path != "" {
+		logInfo(logFields{"config": path}, "Using config %s", path)
+	}
+	return cfg, err
+}
+
+func findConfigFile(targetDir string) string {
+	for _, name := range ConfigFileNames {
+		path := filepath.Join(targetDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadConfigFile reads the file over cfg, so only the keys it sets change.
+func loadConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(data, cfg)
+	} else {
+		err = yaml.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return nil
+}
+
+// splitPatterns splits comma-separated lists of patterns given to a flag.
+func splitPatterns(values []string) []string {
+	patterns := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	return patterns
+}
+
+// finish validates the config and parses the options that need it.
+func (cfg *Config) finish() error {
+	err := setupLogging(cfg.LogFormat, cfg.LogLevel, cfg.Quiet)
+	if err != nil {
+		return err
+	}
+	err = validNaming(cfg.Naming)
+	if err != nil {
+		return err
+	}
+	switch cfg.DiffMode {
+	case "", DiffPlain, DiffInline, DiffSideBySide, DiffUnified:
+	default:
+		return fmt.Errorf("unknown diff mode %q (want %s, %s, %s or %s)", cfg.DiffMode, DiffPlain, DiffInline, DiffSideBySide, DiffUnified)
+	}
+	for _, pattern := range append(append([]string{}, cfg.Only...), cfg.Skip...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid submission pattern %q: %w", pattern, err)
+		}
+	}
+	if cfg.Retries < 0 {
+		return fmt.Errorf("--retries can't be negative, got %d", cfg.Retries)
+	}
+	err = validComparator(cfg.Comparator)
+	if err != nil {
+		return err
+	}
+	for name, comparator := range cfg.CaseComparators {
+		err = validComparator(comparator)
+		if err != nil {
+			return fmt.Errorf("case %s: %w", name, err)
+		}
+	}
+	err = cfg.setupLanguages()
+	if err != nil {
+		return err
+	}
+	switch cfg.Sandbox {
+	case "", SandboxNone:
+		cfg.executor = localExecutor{}
+	case SandboxDocker:
+		cfg.executor = dockerExecutor{Image: cfg.SandboxImage}
+	case SandboxUser:
+		cfg.executor = userExecutor{User: cfg.SandboxUser}
+	default:
+		return fmt.Errorf("unknown sandbox %q (want %s, %s or %s)", cfg.Sandbox, SandboxNone, SandboxDocker, SandboxUser)
+	}
+
+	cfg.formats = make(map[string]bool)
+	for _, format := range strings.Split(cfg.Format, ",") {
+		switch format = strings.TrimSpace(format); format {
+		case FormatText, FormatJSON, FormatHTML:
+			cfg.formats[format] = true
+		case FormatBoth:
+			cfg.formats[FormatText] = true
+			cfg.formats[FormatJSON] = true
+		default:
+			return fmt.Errorf("unknown report format %q (want %s, %s, %s or %s)", format, FormatText, FormatJSON, FormatHTML, FormatBoth)
+		}
+	}
+
+	if cfg.MaxMemory != "" {
+		cfg.maxMemory, err = parseSize(cfg.MaxMemory)
+		if err != nil {
+			return fmt.Errorf("invalid memory limit %q: %w", cfg.MaxMemory, err)
+		}
+	}
+
+	if cfg.MaxMemoryMB < 0 {
+		return fmt.Errorf("--max-memory-mb can't be negative, got %d", cfg.MaxMemoryMB)
+	}
+
+	if cfg.Due != "" {
+		cfg.due, err = parseTime(cfg.Due)
+		if err != nil {
+			return fmt.Errorf("invalid due date %q: %w", cfg.Due, err)
+		}
+	}
+
+	if cfg.Deadline != "" {
+		cfg.deadline, err = time.ParseDuration(cfg.Deadline)
+		if err == nil && cfg.deadline <= 0 {
+			err = fmt.Errorf("must be positive")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid deadline %q: %w", cfg.Deadline, err)
+		}
+		if cfg.Watch || cfg.Serve != "" {
+			return fmt.Errorf("--deadline can't be used with --watch or --serve, which grade until they are stopped")
+		}
+	}
+
+	if cfg.Serve != "" && cfg.Watch {
+		return fmt.Errorf("--serve and --watch can't be used together")
+	}
+
+	if cfg.Schema != "" {
+		cfg.schema, err = parseSchema(cfg.Schema)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Rubric != "" {
+		cfg.rubric, err = loadRubric(cfg.Rubric)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSize parses a byte count with an optional k, m or g suffix.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "b")
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return n * mult, nil
+}
+
+func (cfg *Config) writesText() bool {
+	return cfg.formats[FormatText]
+}
+
+func (cfg *Config) writesJSON() bool {
+	return cfg.formats[FormatJSON]
+}
+
+func (cfg *Config) writesHTML() bool {
+	return cfg.formats[FormatHTML]
+}
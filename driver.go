@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DriverClassPlaceholder is replaced with the submission's class name when a
+// test driver is generated from its template.
+const DriverClassPlaceholder = "{{class}}"
+
+// writeDriver generates a test driver in dir from the template at
+// templatePath, pointing it at the submission's class. The driver's class
+// name is the template's filename (Driver.java -> Driver), so the template
+// should declare a public class with that name.
+func writeDriver(templatePath, dir, className string) (string, error) {
+	template, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("could not read driver template: %w", err)
+	}
+
+	driverClass := strings.TrimSuffix(filepath.Base(templatePath), ".java")
+	if driverClass == className {
+		return "", fmt.Errorf("driver %s has the same class name as the submission", templatePath)
+	}
+
+	src := strings.ReplaceAll(string(template), DriverClassPlaceholder, className)
+	err = os.WriteFile(filepath.Join(dir, driverClass+".java"), []byte(src), 0666)
+	if err != nil {
+		return "", err
+	}
+	return driverClass, nil
+}
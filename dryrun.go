@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// dryRun prints what a run would grade, without compiling or running
+// anything: each submission with its language and the class it would run
+// as, then the test cases in the order they would run.
+func dryRun(subDir, testsDir string, cases []TestCase, cfg *Config) error {
+	jobs, skipped, err := findSubmissions(subDir, cfg)
+	if err != nil {
+		return err
+	}
+	timeouts, err := readCaseTimeouts(testsDir, cases)
+	if err != nil {
+		return err
+	}
+	err = readCasePoints(testsDir, cases)
+	if err != nil {
+		return err
+	}
+
+	width := 0
+	for _, path := range jobs {
+		if len(submissionName(path)) > width {
+			width = len(submissionName(path))
+		}
+	}
+
+	fmt.Printf("Submissions (%d):\n", len(jobs))
+	for _, path := range jobs {
+		lang := cfg.languageName(path)
+		line := fmt.Sprintf("  %-*s  %-6s", width, submissionName(path), lang)
+		if lang == "java" {
+			line += "  " + describeJavaClass(path, cfg.Naming)
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	for _, reason := range skipped {
+		fmt.Printf("  skipped %s\n", reason)
+	}
+
+	fmt.Printf("\nTest cases (%d):\n", len(cases))
+	for i, tc := range cases {
+		line := fmt.Sprintf("  %d. %s -> %s", i+1, tc.In, tc.Out)
+		for _, alt := range tc.Alts {
+			line += " or " + filepath.Base(alt)
+		}
+		if tc.Err != "" {
+			line += ", stderr " + filepath.Base(tc.Err)
+		}
+		if len(tc.Args) != 0 {
+			line += ", args " + shellJoin(tc.Args)
+		}
+		line += fmt.Sprintf(" (%g points", tc.Points)
+		if timeout, ok := timeouts[tc.In]; ok {
+			line += fmt.Sprintf(", %ds timeout", timeout)
+		}
+		fmt.Println(line + ")")
+	}
+
+	families, err := filepath.Glob(filepath.Join(testsDir, "*"+FamilyExt))
+	if err != nil {
+		return err
+	}
+	if len(families) != 0 {
+		fmt.Printf("\n%d test family file(s) would also be expanded by running --reference, which a dry run doesn't do.\n", len(families))
+	}
+	return nil
+}
+
+// describeJavaClass says which class a Java submission would run as, and
+// flags filenames that don't parse or disagree with the source.
+func describeJavaClass(path, naming string) string {
+	named, ok := className(path, naming)
+	if isJavaDir(path) || filepath.Ext(path) == ZipExt {
+		if !ok {
+			return "class picked from its sources (filename doesn't parse)"
+		}
+		return fmt.Sprintf("class picked from its sources (filename says %s)", named)
+	}
+
+	class, err := javaClass(path, naming)
+	if err != nil {
+		return "skipped: no class in the source, and the filename doesn't parse"
+	}
+	if ok && named != class {
+		return fmt.Sprintf("class %s (filename says %s)", class, named)
+	}
+	return "class " + class
+}
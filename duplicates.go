@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// findDuplicates groups the submissions in jobs whose files are identical,
+// and returns which submission each duplicate is a copy of, by name: the
+// first of its group in name order. Under canvas naming, a student's
+// resubmissions of the same file don't count. It is only a first pass at
+// spotting copying, since changing a single character gets past it.
+func findDuplicates(jobs []string, naming string) map[string]string {
+	groups := make(map[string][]string)
+	for _, path := range jobs {
+		hash, err := hashSubmission(path)
+		if err != nil {
+			logWarn(logFields{"submission": path, "error": err}, "Could not check %s for duplicates: %v", path, err)
+			continue
+		}
+		groups[hash] = append(groups[hash], submissionName(path))
+	}
+
+	hashes := make([]string, 0, len(groups))
+	for hash, names := range groups {
+		if len(names) > 1 && !(naming == NamingCanvas && sameStudent(names)) {
+			sort.Strings(names)
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return groups[hashes[i]][0] < groups[hashes[j]][0] })
+
+	duplicateOf := make(map[string]string)
+	for _, hash := range hashes {
+		names := groups[hash]
+		logWarn(logFields{"submissions": names}, "%d submissions are identical: %s", len(names), strings.Join(names, ", "))
+		for _, name := range names[1:] {
+			duplicateOf[name] = names[0]
+		}
+	}
+	return duplicateOf
+}
+
+// sameStudent reports whether canvas-named submissions all come from the
+// same student.
+func sameStudent(names []string) bool {
+	for _, name := range names[1:] {
+		if strings.Split(name, "_")[0] != strings.Split(names[0], "_")[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// hashSubmission is the SHA-256 of a submission's contents. Folders are
+// hashed over every file in them along with its path, so the same files
+// under the same names give the same hash whatever the folder is called.
+func hashSubmission(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if !info.IsDir() {
+		err = hashFile(h, path)
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	files, err := listFiles(path)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file := filepath.Join(path, name)
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		fmt.Fprintf(h, "%q %d\n", filepath.ToSlash(name), info.Size())
+		err = hashFile(h, file)
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FamilyExt is the extension of a test family definition in the testcases
+// folder. A family expands into one concrete case per combination of its
+// parameter values, with expected outputs produced by the --reference
+// solution.
+const FamilyExt = ".family"
+
+// TestFamily is a template input plus the values to fill it with, e.g.
+//
+//	{
+//	  "template": "{{n}} {{m}}\n",
+//	  "params": {"n": [1, 10, 100], "m": "1..3"}
+//	}
+//
+// A parameter is either a list of values or an inclusive "low..high" integer
+// range. {{name}} in the template is replaced by the parameter's value.
+type TestFamily struct {
+	Template string                     `json:"template"`
+	Params   map[string]json.RawMessage `json:"params"`
+}
+
+type familyParam struct {
+	name   string
+	values []string
+}
+
+func parseParamValues(raw json.RawMessage) ([]string, error) {
+	var rng string
+	if json.Unmarshal(raw, &rng) == nil {
+		bounds := strings.SplitN(rng, "..", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("range %q must look like low..high", rng)
+		}
+		low, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, err
+		}
+		high, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]string, 0)
+		for i := low; i <= high; i++ {
+			values = append(values, strconv.Itoa(i))
+		}
+		return values, nil
+	}
+
+	var list []interface{}
+	err := json.Unmarshal(raw, &list)
+	if err != nil {
+		return nil, fmt.Errorf("must be a list of values or a \"low..high\" range")
+	}
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		values = append(values, fmt.Sprint(v))
+	}
+	return values, nil
+}
+
+// inputs fills in the template with every combination of parameter values.
+// Parameters are combined in name order so the expansion is deterministic.
+func (fam *TestFamily) inputs() ([]string, error) {
+	params := make([]familyParam, 0, len(fam.Params))
+	for name, raw := range fam.Params {
+		values, err := parseParamValues(raw)
+		if err != nil {
+			return nil, fmt.Errorf("param %q: %w", name, err)
+		}
+		params = append(params, familyParam{name: name, values: values})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		return params[i].name < params[j].name
+	})
+
+	inputs := []string{fam.Template}
+	for _, p := range params {
+		next := make([]string, 0, len(inputs)*len(p.values))
+		for _, in := range inputs {
+			for _, v := range p.values {
+				next = append(next, strings.ReplaceAll(in, "{{"+p.name+"}}", v))
+			}
+		}
+		inputs = next
+	}
+	return inputs, nil
+}
+
+// expandFamilies writes the concrete cases for every family in testsDir into
+// genDir and returns their paths. Expected outputs come from running the
+// reference solution on each generated input.
+func expandFamilies(ctx context.Context, testsDir, genDir string, cfg *Config, namer *dirNamer) (cases []TestCase, err error) {
+	families, err := filepath.Glob(filepath.Join(testsDir, "*"+FamilyExt))
+	if err != nil || len(families) == 0 {
+		return nil, err
+	}
+	if cfg.Reference == "" {
+		return nil, fmt.Errorf("%s defines test families, but no --reference solution was given to produce their expected output", testsDir)
+	}
+
+	os.RemoveAll(genDir)
+	err = os.Mkdir(genDir, 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(families)
+	for _, famPath := range families {
+		data, err := os.ReadFile(famPath)
+		if err != nil {
+			return nil, err
+		}
+
+		fam := &TestFamily{}
+		err = json.Unmarshal(data, fam)
+		if err != nil {
+			return nil, fmt.Errorf("invalid test family %s: %w", famPath, err)
+		}
+		inputs, err := fam.inputs()
+		if err != nil {
+			return nil, fmt.Errorf("invalid test family %s: %w", famPath, err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(famPath), FamilyExt)
+		width := len(strconv.Itoa(len(inputs)))
+		famIn := make([]string, 0, len(inputs))
+		for i, input := range inputs {
+			path := filepath.Join(genDir, fmt.Sprintf("%s-%0*d.in", name, width, i+1))
+			err = os.WriteFile(path, []byte(input), 0666)
+			if err != nil {
+				return nil, err
+			}
+			famIn = append(famIn, path)
+		}
+
+		logInfo(logFields{"family": name, "cases": len(famIn)}, "Generating expected output for the %d case(s) of test family %s...", len(famIn), name)
+		famOut, err := runReference(ctx, famIn, cfg, namer)
+		if err != nil {
+			return nil, fmt.Errorf("test family %s: %w", name, err)
+		}
+
+		for i := range famIn {
+			cases = append(cases, TestCase{In: famIn[i], Out: famOut[i]})
+		}
+	}
+	return cases, nil
+}
+
+// runReference runs the reference solution on each input and saves what it
+// prints next to the input as the expected output.
+func runReference(ctx context.Context, inFiles []string, cfg *Config, namer *dirNamer) ([]string, error) {
+	dir := namer.name(cfg.Reference)
+	defer os.RemoveAll(dir)
+
+	lang := cfg.languageFor(cfg.Reference)
+	var file string
+	var err error
+	if _, ok := lang.(*JavaLanguage); ok {
+		// The reference is named after its class, not in the canvas format
+		file = filepath.Base(cfg.Reference)
+		err = os.Mkdir(dir, 0777)
+		if err == nil {
+			_, err = copyFile(cfg.Reference, filepath.Join(dir, file))
+		}
+	} else {
+		file, err = lang.Setup(cfg.Reference, dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	compRes := lang.Compile(ctx, dir, file)
+	if compRes != nil && compRes.Status == STATUS_COMPILE_ERR {
+		return nil, fmt.Errorf("reference solution did not compile:\n%s", compRes.err)
+	}
+
+	outFiles := make([]string, 0, len(inFiles))
+	for _, inFile := range inFiles {
+		res, err := lang.Run(ctx, dir, file, inFile, nil, cfg.limits())
+		if err != nil {
+			return nil, err
+		}
+		if res.Status != STATUS_OK {
+			return nil, fmt.Errorf("reference solution got %s on %s:\n%s", res.Status, inFile, res.err)
+		}
+
+		outFile := strings.TrimSuffix(inFile, ".in") + ".out"
+		err = os.WriteFile(outFile, []byte(res.out), 0666)
+		if err != nil {
+			return nil, err
+		}
+		outFiles = append(outFiles, outFile)
+	}
+	return outFiles, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// historySchema is the layout of the --db database. Each grading of a
+// submission adds a row to submissions, with one test_results row per case.
+var historySchema = []string{
+	`CREATE TABLE IF NOT EXISTS submissions (
+		id         INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		assignment TEXT NOT NULL,
+		graded_at  TEXT NOT NULL,
+		score      REAL NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS test_results (
+		submission_id INTEGER NOT NULL REFERENCES submissions(id),
+		test_case     TEXT NOT NULL,
+		status        TEXT NOT NULL,
+		passed        INTEGER NOT NULL,
+		duration_ms   INTEGER NOT NULL,
+		diff          TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS test_results_submission ON test_results(submission_id)`,
+}
+
+// resultHistory saves graded submissions to the SQLite database given by
+// --db, so past runs can be queried without grading again.
+type resultHistory struct {
+	mu         sync.Mutex
+	db         *sql.DB
+	assignment string
+}
+
+var history = &resultHistory{}
+
+// open creates the database at path if needed. Submissions saved from then
+// on are filed under assignment.
+func (h *resultHistory) open(path, assignment string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	// One writer at a time, so concurrent saves wait instead of failing on a
+	// locked database
+	db.SetMaxOpenConns(1)
+	for _, stmt := range historySchema {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("could not set up %s: %w", path, err)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.db = db
+	h.assignment = assignment
+	return nil
+}
+
+func (h *resultHistory) close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.db == nil {
+		return nil
+	}
+	err := h.db.Close()
+	h.db = nil
+	return err
+}
+
+// record saves graded submissions in one transaction. It does nothing
+// unless --db is set.
+func (h *resultHistory) record(cases []TestCase, subs []*Submission) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.db == nil {
+		return nil
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	gradedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, sub := range subs {
+		err = recordSubmission(tx, h.assignment, gradedAt, cases, sub)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func recordSubmission(tx *sql.Tx, assignment, gradedAt string, cases []TestCase, sub *Submission) error {
+	row, err := tx.Exec(`INSERT INTO submissions (name, assignment, graded_at, score) VALUES (?, ?, ?, ?)`,
+		sub.Name, assignment, gradedAt, sub.Score)
+	if err != nil {
+		return err
+	}
+	id, err := row.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for i, res := range sub.RunResults {
+		var diff sql.NullString
+		if res.Graded() && !res.Match && res.FormatError() == "" {
+			diff = sql.NullString{String: renderDiff(res.Diffs(), DiffPlain), Valid: true}
+		}
+		_, err = tx.Exec(`INSERT INTO test_results (submission_id, test_case, status, passed, duration_ms, diff) VALUES (?, ?, ?, ?, ?, ?)`,
+			id, cases[i].Out, res.Status.String(), res.Passed(), res.Duration.Milliseconds(), diff)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignmentName files a run's results under the name of its target
+// folder.
+func assignmentName(targetDir string) string {
+	abs, err := filepath.Abs(targetDir)
+	if err != nil {
+		abs = targetDir
+	}
+	return filepath.Base(abs)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path/filepath"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const htmlStyle = `<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; }
+.pass { color: #070; } .fail { color: #b00; }
+table { border-collapse: collapse; } td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; }
+.tok-keyword { color: #00b; font-weight: bold; } .tok-string { color: #a11; } .tok-number { color: #085; } .tok-comment { color: #777; font-style: italic; }
+</style>`
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Report for {{.Name}}</title>` + htmlStyle + `</head>
+<body>
+<p><a href="index.html">&larr; All submissions</a></p>
+<h1>Report for {{.Name}}</h1>
+<p>Score: <b>{{.Points}} / {{.MaxPoints}}</b> ({{printf "%.2f" .Score}}%){{if .LatePenalty}} (raw {{printf "%.2f" .RawScore}}%, late {{.DaysLate}} day(s) -{{.LatePenalty}}%){{end}}</p>
+{{if .DuplicateOf}}<p class="fail">This submission is identical to <a href="{{.DuplicateOf}}.html">{{.DuplicateOf}}</a>.</p>{{end}}
+{{if .Error}}
+<h2>Compile result: SKIPPED (could not be graded)</h2>
+<p class="fail">{{.Error}}</p>
+{{else}}{{with .Compile}}
+<h2>Compile result: {{.Status}}</h2>
+<p>Compile time: {{printf "%.2f" .Seconds}}s</p>
+{{if .Err}}<pre>{{.Err}}</pre>{{end}}
+{{if .Out}}<pre>{{.Out}}</pre>{{end}}
+{{else}}
+<h2>Compile result: SKIPPED (nothing to compile)</h2>
+{{end}}{{end}}
+{{if .Stray}}<p class="fail">The program left unexpected files in its working directory: {{range .Stray}}<code>{{.}}</code> {{end}}</p>{{end}}
+<h2>Test cases</h2>
+{{range .HTMLCases}}
+<details{{if not .Passed}} open{{end}}>
+<summary class="{{if .Passed}}pass{{else}}fail{{end}}">{{.Case}}: {{.Status}}{{if .Reason}} ({{.Reason}}){{end}} in {{printf "%.3f" .Seconds}}s{{if .MemoryKB}}, {{.MemoryKB}} KB{{end}}</summary>
+{{if .Args}}<p>Arguments: {{range .Args}}<code>{{.}}</code> {{end}}</p>{{end}}
+{{if gt .Attempts 1}}<p>Ran {{.Attempts}} times{{if .Passed}}, and only passed on a retry{{end}}.</p>{{end}}
+{{if .Note}}<p>Note: {{.Note}}</p>{{end}}
+{{if .FormatError}}<p>Output format invalid: {{.FormatError}}</p>{{end}}
+{{if .Err}}<h4>Error log</h4><pre>{{.Err}}</pre>{{end}}
+{{if .DiffHTML}}<h4>Diff</h4><pre>{{.DiffHTML}}</pre>{{end}}
+{{if .StderrDiff}}<h4>Stderr diff</h4><pre>{{.StderrDiff}}</pre>{{end}}
+{{if and .HasDiff .Out}}<h4>Output</h4><pre>{{.Out}}</pre>{{end}}
+</details>
+{{end}}
+{{if .Sources}}
+<h2>Source</h2>
+{{range .Sources}}
+<details open>
+<summary><code>{{.Name}}</code></summary>
+<pre><code{{with .Lang}} data-lang="{{.}}"{{end}}>{{.Text}}</code></pre>
+{{if .Truncated}}<p>Cut off after {{$.MaxSourceBytes}} bytes.</p>{{end}}
+</details>
+{{end}}
+` + htmlHighlight + `
+{{end}}
+</body></html>
+`))
+
+var htmlIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Submission reports</title>` + htmlStyle + `</head>
+<body>
+<h1>Submission reports</h1>
+<table>
+<tr><th>Submission</th><th>Compile</th><th>Passed</th><th>Failed</th><th>Timeout</th><th>Score</th></tr>
+{{range .}}
+<tr><td><a href="{{.Name}}.html">{{.Name}}</a></td><td>{{.Compile}}</td><td class="pass">{{.Passed}}</td><td class="fail">{{.Failed}}</td><td>{{.Timeout}}</td><td>{{printf "%.2f" .Score}}%</td></tr>
+{{end}}
+</table>
+</body></html>
+`))
+
+// htmlReport is the JSON report plus the diffs rendered as HTML, and the
+// source files if they are included.
+type htmlReport struct {
+	*jsonReport
+	HTMLCases      []htmlCase
+	Sources        []htmlSource
+	MaxSourceBytes int
+}
+
+type htmlSource struct {
+	SourceFile
+	Lang string
+}
+
+type htmlCase struct {
+	jsonCase
+	DiffHTML template.HTML
+}
+
+func writeHTMLReport(repDir string, cases []TestCase, sub *Submission) error {
+	rep := htmlReport{jsonReport: newJSONReport(cases, sub), MaxSourceBytes: MaxSourceBytes}
+	for _, src := range sub.Sources {
+		rep.Sources = append(rep.Sources, htmlSource{SourceFile: src, Lang: sourceLanguage(src.Name)})
+	}
+	dmp := diffmatchpatch.New()
+	for i, c := range rep.Cases {
+		hc := htmlCase{jsonCase: c}
+		if c.HasDiff {
+			hc.DiffHTML = template.HTML(dmp.DiffPrettyHtml(sub.RunResults[i].Diffs()))
+		}
+		rep.HTMLCases = append(rep.HTMLCases, hc)
+	}
+
+	buf := &bytes.Buffer{}
+	err := htmlReportTemplate.Execute(buf, rep)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(repDir, sub.Name+".html"), buf.Bytes())
+}
+
+// writeHTMLIndex writes index.html, linking to every submission's page with
+// a summary of how it did.
+func writeHTMLIndex(repDir string, rows []summaryRow) error {
+	f, err := os.Create(filepath.Join(repDir, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return htmlIndexTemplate.Execute(f, rows)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// Report formats accepted by --format
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatHTML = "html"
+	FormatBoth = "both" // text and JSON
+)
+
+// jsonReport is the JSON form of a submission's report.
+type jsonReport struct {
+	Name        string      `json:"name"`
+	Score       float64     `json:"score"`
+	Points      float64     `json:"points"`
+	MaxPoints   float64     `json:"maxPoints"`
+	RawScore    float64     `json:"rawScore,omitempty"`
+	DaysLate    int         `json:"daysLate,omitempty"`
+	LatePenalty float64     `json:"latePenalty,omitempty"`
+	Compile     *jsonResult `json:"compile"`
+	Cases       []jsonCase  `json:"cases"`
+	Stray       []string    `json:"strayFiles,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Incomplete  bool        `json:"incomplete,omitempty"`
+	DuplicateOf string      `json:"duplicateOf,omitempty"`
+}
+
+type jsonResult struct {
+	Status   string  `json:"status"`
+	Out      string  `json:"out,omitempty"`
+	Err      string  `json:"err,omitempty"`
+	Seconds  float64 `json:"seconds"`
+	ExitCode int     `json:"exitCode"`
+	Signal   string  `json:"signal,omitempty"`
+	MemoryKB int64   `json:"memoryKB,omitempty"`
+}
+
+type jsonCase struct {
+	Case string `json:"case"`
+	jsonResult
+	Passed      bool     `json:"passed"`
+	HasDiff     bool     `json:"hasDiff"`
+	Diff        string   `json:"diff,omitempty"`
+	StderrDiff  string   `json:"stderrDiff,omitempty"`
+	FormatError string   `json:"formatError,omitempty"`
+	Note        string   `json:"note,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+	Retried     bool     `json:"retried,omitempty"`
+	Attempts    int      `json:"attempts,omitempty"`
+	Args        []string `json:"args,omitempty"`
+}
+
+func newJSONResult(res *Result) *jsonResult {
+	if res == nil {
+		return nil
+	}
+	r := &jsonResult{Status: res.Status.String(), Out: res.Out(), Err: res.Err(), Seconds: res.Duration.Seconds(), ExitCode: res.ExitCode, MemoryKB: res.MemoryKB}
+	if res.Signal != 0 {
+		r.Signal = res.Signal.String()
+	}
+	return r
+}
+
+func newJSONReport(cases []TestCase, sub *Submission) *jsonReport {
+	rep := &jsonReport{
+		Name:        sub.Name,
+		Score:       sub.Score,
+		Points:      sub.Points,
+		MaxPoints:   sub.MaxPoints,
+		Compile:     newJSONResult(sub.CompileResult),
+		Cases:       make([]jsonCase, 0, len(sub.RunResults)),
+		Stray:       sub.Stray,
+		Error:       sub.Failure,
+		Incomplete:  sub.Incomplete,
+		DuplicateOf: sub.DuplicateOf,
+	}
+	if sub.LatePenalty != 0 {
+		rep.RawScore = sub.RawScore
+		rep.DaysLate = sub.DaysLate
+		rep.LatePenalty = sub.LatePenalty
+	}
+
+	for i, res := range sub.RunResults {
+		c := jsonCase{
+			Case:        cases[i].Out,
+			jsonResult:  *newJSONResult(res),
+			Passed:      res.Passed(),
+			FormatError: res.FormatError(),
+			Note:        res.Note(),
+			Reason:      res.Reason(),
+			Retried:     res.Retried,
+			Attempts:    res.Attempts,
+			Args:        res.Args,
+		}
+		if res.Graded() && !res.Match && res.FormatError() == "" {
+			c.HasDiff = true
+			c.Diff = renderDiff(res.Diffs(), DiffPlain)
+		}
+		if res.Graded() && res.StderrMismatch() {
+			c.StderrDiff = renderDiff(res.StderrDiffs(), DiffPlain)
+		}
+		rep.Cases = append(rep.Cases, c)
+	}
+	return rep
+}
+
+func writeJSONReport(repDir string, cases []TestCase, sub *Submission) error {
+	data, err := json.MarshalIndent(newJSONReport(cases, sub), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(repDir, sub.Name+".json"), append(data, '\n'))
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Language knows how to build and run submissions written in it.
+type Language interface {
+	// Setup copies the submission at path into the test folder dir and
+	// returns the name of the file to compile and run, relative to dir.
+	Setup(path, dir string) (file string, err error)
+
+	// Compile builds file inside dir. It returns nil if there is nothing to
+	// compile.
+	Compile(ctx context.Context, dir, file string) *Result
+
+	// Run runs the built program inside dir with the stdin file as input,
+	// passing it args on its command line.
+	Run(ctx context.Context, dir, file, stdin string, args []string, limits RunLimits) (*Result, error)
+}
+
+// cachedCompiler is a Language whose compile step can be kept in
+// --cache-dir. compileSpec gives the command that prints the compiler's
+// version and a description of the build; both go into the cache key along
+// with the sources, so upgrading the compiler or changing how it is called
+// rebuilds everything.
+type cachedCompiler interface {
+	compileSpec() (version, build []string)
+}
+
+// JavaLanguage compiles with javac and runs the class named after file.
+type JavaLanguage struct {
+	JVMFlags []string
+	Naming   string
+}
+
+// Setup names the file after the class in the filename. Zipped
+// submissions run whichever class in the archive has the main method, and
+// submission folders have all their .java files copied in.
+func (l *JavaLanguage) Setup(path, dir string) (string, error) {
+	if filepath.Ext(path) == ZipExt {
+		class, err := makeZipTestDir(path, dir, l.Naming)
+		return class + ".java", err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		class, err := makeDirTestDir(path, dir, l.Naming)
+		return class + ".java", err
+	}
+	class, err := makeTestDir(path, dir, l.Naming)
+	return class + ".java", err
+}
+
+// forSubmission is the language to grade the submission at path with. A
+// jvmFlags= line in its .meta file, e.g. jvmFlags=-Xss16m -Xmx512m, replaces
+// --jvm-flags for that submission.
+func (l *JavaLanguage) forSubmission(path string) (*JavaLanguage, error) {
+	meta, err := readMeta(path + MetaExt)
+	if err != nil {
+		return nil, err
+	}
+	flags, ok := meta["jvmflags"]
+	if !ok {
+		return l, nil
+	}
+	return &JavaLanguage{JVMFlags: strings.Fields(flags), Naming: l.Naming}, nil
+}
+
+// Compile builds every .java file in dir, so helper classes such as a test
+// driver are compiled along with the submission.
+func (l *JavaLanguage) Compile(ctx context.Context, dir, file string) *Result {
+	srcs, err := filepath.Glob(filepath.Join(dir, "*.java"))
+	if err != nil || len(srcs) == 0 {
+		srcs = []string{file}
+	}
+	for i := range srcs {
+		srcs[i] = filepath.Base(srcs[i])
+	}
+	return runCompile(ctx, dir, append([]string{"javac"}, srcs...))
+}
+
+func (l *JavaLanguage) compileSpec() (version, build []string) {
+	return []string{"javac", "-version"}, []string{"javac", "*.java"}
+}
+
+func (l *JavaLanguage) Run(ctx context.Context, dir, file, stdin string, args []string, limits RunLimits) (*Result, error) {
+	command := javaCommand(l.JVMFlags, &limits)
+	command = append(command, "-classpath", ".", strings.TrimSuffix(file, ".java"))
+	return runExec(ctx, dir, append(command, args...), stdin, limits)
+}
+
+// javaCommand starts a java command line with jvmFlags. The JVM reserves far
+// more address space than it uses, so any memory limit caps the heap
+// instead of the whole process, and is taken out of limits. A container
+// still gets the whole limit.
+func javaCommand(jvmFlags []string, limits *RunLimits) []string {
+	command := []string{"java"}
+	if limits.MaxMemoryBytes > 0 {
+		command = append(command, fmt.Sprintf("-Xmx%dk", limits.MaxMemoryBytes/1024))
+		limits.MaxMemoryBytes = 0
+	}
+	return append(command, jvmFlags...)
+}
+
+// KotlinLanguage compiles with kotlinc into a jar with the Kotlin runtime
+// bundled, and runs it with java -jar. Kotlin doesn't tie class names to
+// file names, so the file keeps its submitted name.
+type KotlinLanguage struct {
+	JVMFlags []string
+}
+
+func (l *KotlinLanguage) Setup(path, dir string) (string, error) {
+	return copyIntoDir(path, dir)
+}
+
+func (l *KotlinLanguage) Compile(ctx context.Context, dir, file string) *Result {
+	return runCompile(ctx, dir, []string{"kotlinc", file, "-include-runtime", "-d", binaryName(file) + ".jar"})
+}
+
+func (l *KotlinLanguage) compileSpec() (version, build []string) {
+	return []string{"kotlinc", "-version"}, []string{"kotlinc", "{src}", "-include-runtime", "-d", "{class}.jar"}
+}
+
+func (l *KotlinLanguage) Run(ctx context.Context, dir, file, stdin string, args []string, limits RunLimits) (*Result, error) {
+	command := javaCommand(l.JVMFlags, &limits)
+	command = append(command, "-jar", binaryName(file)+".jar")
+	return runExec(ctx, dir, append(command, args...), stdin, limits)
+}
+
+// PythonLanguage runs the file with python3, with no compile step.
+type PythonLanguage struct{}
+
+func (l *PythonLanguage) Setup(path, dir string) (string, error) {
+	return copyIntoDir(path, dir)
+}
+
+func (l *PythonLanguage) Compile(ctx context.Context, dir, file string) *Result {
+	return nil
+}
+
+func (l *PythonLanguage) Run(ctx context.Context, dir, file, stdin string, args []string, limits RunLimits) (*Result, error) {
+	return runExec(ctx, dir, append([]string{"python3", file}, args...), stdin, limits)
+}
+
+// CLanguage compiles C with gcc into a binary named after the file.
+type CLanguage struct{}
+
+func (l *CLanguage) Setup(path, dir string) (string, error) {
+	return copyIntoDir(path, dir)
+}
+
+func (l *CLanguage) Compile(ctx context.Context, dir, file string) *Result {
+	return runCompile(ctx, dir, []string{"gcc", "-o", binaryName(file), file})
+}
+
+func (l *CLanguage) compileSpec() (version, build []string) {
+	return []string{"gcc", "--version"}, []string{"gcc", "-o", "{class}", "{src}"}
+}
+
+func (l *CLanguage) Run(ctx context.Context, dir, file, stdin string, args []string, limits RunLimits) (*Result, error) {
+	return runExec(ctx, dir, append([]string{"./" + binaryName(file)}, args...), stdin, limits)
+}
+
+// CppLanguage compiles C++ with g++ into a binary named after the file.
+type CppLanguage struct{}
+
+func (l *CppLanguage) Setup(path, dir string) (string, error) {
+	return copyIntoDir(path, dir)
+}
+
+func (l *CppLanguage) Compile(ctx context.Context, dir, file string) *Result {
+	return runCompile(ctx, dir, []string{"g++", "-o", binaryName(file), file})
+}
+
+func (l *CppLanguage) compileSpec() (version, build []string) {
+	return []string{"g++", "--version"}, []string{"g++", "-o", "{class}", "{src}"}
+}
+
+func (l *CppLanguage) Run(ctx context.Context, dir, file, stdin string, args []string, limits RunLimits) (*Result, error) {
+	return runExec(ctx, dir, append([]string{"./" + binaryName(file)}, args...), stdin, limits)
+}
+
+// binaryName is the stem of a source file, used for the compiled program.
+func binaryName(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file))
+}
+
+// execLanguage runs scripts and prebuilt binaries as they are.
+type execLanguage struct{}
+
+func (l execLanguage) Setup(path, dir string) (string, error) {
+	return makeExecDir(path, dir)
+}
+
+func (l execLanguage) Compile(ctx context.Context, dir, file string) *Result {
+	return nil
+}
+
+func (l execLanguage) Run(ctx context.Context, dir, file, stdin string, args []string, limits RunLimits) (*Result, error) {
+	return runExec(ctx, dir, append([]string{"./" + file}, args...), stdin, limits)
+}
+
+// CommandLanguage is a language described by command templates, so new ones
+// can be added in the config file. Commands run inside the test folder, and
+// may use these placeholders:
+//   - {dir}: absolute path of the test folder
+//   - {src}: the source file, relative to the test folder
+//   - {class}: the source file name without its extension
+//
+// Languages without a compile command are run straight from source.
+type CommandLanguage struct {
+	Name    string   `yaml:"name" json:"name"`
+	Exts    []string `yaml:"exts" json:"exts"`
+	Compile []string `yaml:"compile" json:"compile"`
+	Run     []string `yaml:"run" json:"run"`
+}
+
+// commandLanguage adapts a CommandLanguage to the Language interface; its
+// fields are named after the config keys, which clash with the methods.
+type commandLanguage struct {
+	*CommandLanguage
+}
+
+func (l commandLanguage) Setup(path, dir string) (string, error) {
+	return copyIntoDir(path, dir)
+}
+
+func (l commandLanguage) Compile(ctx context.Context, dir, file string) *Result {
+	if len(l.CommandLanguage.Compile) == 0 {
+		return nil
+	}
+	return runCompile(ctx, dir, l.command(l.CommandLanguage.Compile, dir, file))
+}
+
+// compileSpec asks the compile command's program for --version, which most
+// compilers understand. Languages with no compile step aren't cached.
+func (l commandLanguage) compileSpec() (version, build []string) {
+	if len(l.CommandLanguage.Compile) == 0 {
+		return nil, nil
+	}
+	return []string{l.CommandLanguage.Compile[0], "--version"}, l.CommandLanguage.Compile
+}
+
+func (l commandLanguage) Run(ctx context.Context, dir, file, stdin string, args []string, limits RunLimits) (*Result, error) {
+	return runExec(ctx, dir, append(l.command(l.CommandLanguage.Run, dir, file), args...), stdin, limits)
+}
+
+// command fills in a command template.
+func (l commandLanguage) command(tmpl []string, dir, file string) []string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	r := strings.NewReplacer("{dir}", abs, "{class}", binaryName(file), "{src}", file)
+
+	command := make([]string, 0, len(tmpl))
+	for _, arg := range tmpl {
+		command = append(command, r.Replace(arg))
+	}
+	return command
+}
+
+func (l *CommandLanguage) validate() error {
+	if l.Name == "" {
+		return fmt.Errorf("language with no name in config")
+	}
+	if len(l.Run) == 0 {
+		return fmt.Errorf("language %s has no run command", l.Name)
+	}
+	return nil
+}
+
+// setupLanguages registers the built-in languages and any from the config,
+// which replace built-in ones of the same name.
+func (cfg *Config) setupLanguages() error {
+	cfg.langs = map[string]Language{
+		"java":   &JavaLanguage{JVMFlags: cfg.JVMFlags, Naming: cfg.Naming},
+		"kotlin": &KotlinLanguage{JVMFlags: cfg.JVMFlags},
+		"python": &PythonLanguage{},
+		"c":      &CLanguage{},
+		"cpp":    &CppLanguage{},
+		"exec":   execLanguage{},
+	}
+	cfg.exts = map[string]string{
+		".java": "java",
+		ZipExt:  "java",
+		".kt":   "kotlin",
+		".py":   "python",
+		".c":    "c",
+		".cpp":  "cpp",
+		".cc":   "cpp",
+		".cxx":  "cpp",
+	}
+
+	for _, l := range cfg.Languages {
+		err := l.validate()
+		if err != nil {
+			return err
+		}
+		cfg.langs[l.Name] = commandLanguage{l}
+		for _, ext := range l.Exts {
+			cfg.exts[ext] = l.Name
+		}
+	}
+
+	if cfg.Language != "" && cfg.langs[cfg.Language] == nil {
+		return fmt.Errorf("unknown language %q", cfg.Language)
+	}
+	return nil
+}
+
+// languageFor picks the language of the submission at path: --language if
+// given, otherwise by extension. Folders of .java files are Java, and files
+// with no known extension are run directly if they look like a script or
+// binary, and treated as Java if not.
+func (cfg *Config) languageFor(path string) Language {
+	return cfg.langs[cfg.languageName(path)]
+}
+
+// languageName is the name of the language languageFor picks.
+func (cfg *Config) languageName(path string) string {
+	if cfg.Language != "" {
+		return cfg.Language
+	}
+	if isJavaDir(path) {
+		return "java"
+	}
+	if name, ok := cfg.exts[filepath.Ext(path)]; ok {
+		return name
+	}
+	if isDirectExec(path) {
+		return "exec"
+	}
+	return "java"
+}
+
+// copyIntoDir sets up a test folder holding the submission under its
+// original filename.
+func copyIntoDir(path, dir string) (string, error) {
+	err := os.Mkdir(dir, 0777)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Base(path)
+	_, err = copyFile(path, filepath.Join(dir, file))
+	return file, err
+}
+
+// runCompile runs a compile command inside dir. The compiler is killed if
+// ctx is cancelled.
+func runCompile(ctx context.Context, dir string, command []string) *Result {
+	outBuff := &bytes.Buffer{}
+	errBuff := &bytes.Buffer{}
+	compCmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	compCmd.Dir = dir
+	compCmd.Stdout = outBuff
+	compCmd.Stderr = errBuff
+
+	// Run compile Command
+	logDebug(logFields{"dir": dir, "command": command}, "compiling in %s: %s", dir, strings.Join(command, " "))
+	start := time.Now()
+	err := compCmd.Run()
+
+	compRes := &Result{
+		Duration: time.Since(start),
+		out:      outBuff.String(),
+		err:      errBuff.String(),
+	}
+	compRes.recordExit(err)
+
+	if err != nil {
+		compRes.Status = STATUS_COMPILE_ERR
+		if compRes.err == "" {
+			compRes.err = err.Error()
+		}
+	} else if strings.TrimSpace(compRes.err) != "" {
+		// Compiled, but the compiler had something to say about the code
+		compRes.Status = STATUS_WARN
+	} else {
+		compRes.Status = STATUS_OK
+	}
+
+	return compRes
+}
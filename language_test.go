@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCSubmissionThatFailsToLink(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc is not installed")
+	}
+	tmp := t.TempDir()
+	// Compiles, since solve is declared, but there is nothing to link it to
+	sub := filepath.Join(tmp, "submissions", "abc_1_2_solve.c")
+	writeFile(t, sub, "int solve(int n);\n\nint main(void) {\n\treturn solve(3);\n}\n", 0644)
+	writeFile(t, filepath.Join(tmp, "testcases", "1.in"), "", 0644)
+	writeFile(t, filepath.Join(tmp, "testcases", "1.out"), "", 0644)
+
+	cfg := testConfig(t, "--path", tmp)
+	cases := []TestCase{{
+		In:  filepath.Join(tmp, "testcases", "1.in"),
+		Out: filepath.Join(tmp, "testcases", "1.out"),
+	}}
+	got, err := runSubmission(context.Background(), sub, filepath.Join(tmp, "work"), cases, nil, cfg)
+	if err != nil {
+		t.Fatalf("runSubmission: %v", err)
+	}
+	if got.CompileResult == nil || got.CompileResult.Status != STATUS_COMPILE_ERR {
+		t.Fatalf("compile result = %+v, want %v", got.CompileResult, STATUS_COMPILE_ERR)
+	}
+	if !strings.Contains(got.CompileResult.Err(), "solve") {
+		t.Errorf("compiler output %q doesn't mention the undefined function", got.CompileResult.Err())
+	}
+	for i, res := range got.RunResults {
+		if res.Status != STATUS_SKIPPED {
+			t.Errorf("case %d status = %v, want %v", i+1, res.Status, STATUS_SKIPPED)
+		}
+	}
+}
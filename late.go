@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// MetaExt is the extension of the optional key=value sidecar file that can
+// sit next to a submission, e.g. doe_12345_67890_Main.java.meta.
+const MetaExt = ".meta"
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseTime(s string) (time.Time, error) {
+	var err error
+	for _, layout := range timeLayouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, strings.TrimSpace(s), time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// readMeta parses a sidecar file of key=value lines. Blank lines and lines
+// starting with # are ignored. A missing file is not an error.
+func readMeta(path string) (map[string]string, error) {
+	meta := make(map[string]string)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		meta[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	return meta, scanner.Err()
+}
+
+// readSubmittedAt returns the submitted= time from a submission's sidecar
+// meta file, or the zero time if it doesn't have one.
+func readSubmittedAt(path string) (time.Time, error) {
+	meta, err := readMeta(path + MetaExt)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	submitted, ok := meta["submitted"]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return parseTime(submitted)
+}
+
+// applyLatePenalty takes cfg.LatePenalty percent off the score for every
+// started day the submission was made past cfg.Due.
+func applyLatePenalty(sub *Submission, cfg *Config) {
+	sub.RawScore = sub.Score
+	sub.DaysLate = 0
+	sub.LatePenalty = 0
+	if cfg.due.IsZero() || sub.SubmittedAt.IsZero() || !sub.SubmittedAt.After(cfg.due) {
+		return
+	}
+
+	sub.DaysLate = int(math.Ceil(sub.SubmittedAt.Sub(cfg.due).Hours() / 24))
+	sub.LatePenalty = math.Min(100, float64(sub.DaysLate)*cfg.LatePenalty)
+	sub.Score = sub.RawScore * (1 - sub.LatePenalty/100)
+}
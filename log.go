@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats accepted by --log-format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+type logLevel int
+
+const (
+	LevelDebug logLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+func parseLogLevel(name string) (logLevel, error) {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(name, n) || (n == "WARN" && strings.EqualFold(name, "warning")) {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", name)
+}
+
+// logFields are extra key/value pairs for a log line. They are only written
+// out in JSON logs; text logs are meant to be read as they are.
+type logFields map[string]interface{}
+
+// logger writes progress messages, either as plain lines or as one JSON
+// object per line for tools that parse them.
+type logger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level logLevel
+	json  bool
+	color bool
+	quiet bool // leave out per-submission progress
+}
+
+var progressLog = &logger{w: os.Stdout, level: LevelInfo}
+
+// setupLogging configures the progress log from the --log-format,
+// --log-level and --quiet settings.
+func setupLogging(format, level string, quiet bool) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("unknown log format %q (want %s or %s)", format, LogFormatText, LogFormatJSON)
+	}
+
+	progressLog.mu.Lock()
+	defer progressLog.mu.Unlock()
+	progressLog.level = lvl
+	progressLog.json = format == LogFormatJSON
+	progressLog.quiet = quiet
+	progressLog.color = !progressLog.json && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ANSI colors for verdicts in the progress log
+const (
+	ColorRed    = "\033[31m"
+	ColorGreen  = "\033[32m"
+	ColorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// useColor reports whether the progress log is going to a terminal that
+// takes colors.
+func useColor() bool {
+	progressLog.mu.Lock()
+	defer progressLog.mu.Unlock()
+	return progressLog.color
+}
+
+// colorize wraps s in an ANSI color if the progress log is going to a
+// terminal, and NO_COLOR isn't set.
+func colorize(s, color string) string {
+	progressLog.mu.Lock()
+	defer progressLog.mu.Unlock()
+	if !progressLog.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func (l *logger) log(level logLevel, fields logFields, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if !l.json {
+		switch level {
+		case LevelDebug, LevelError:
+			msg = level.String() + ": " + msg
+		case LevelWarn:
+			msg = "WARNING: " + msg
+		}
+		fmt.Fprintln(l.w, msg)
+		return
+	}
+
+	entry := map[string]interface{}{}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().Format(time.RFC3339)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": level.String(), "msg": msg})
+	}
+	l.w.Write(append(line, '\n'))
+}
+
+func logDebug(fields logFields, format string, args ...interface{}) {
+	progressLog.log(LevelDebug, fields, format, args...)
+}
+
+func logInfo(fields logFields, format string, args ...interface{}) {
+	progressLog.log(LevelInfo, fields, format, args...)
+}
+
+// logProgress logs per-submission progress, which --quiet leaves out.
+func logProgress(fields logFields, format string, args ...interface{}) {
+	if progressLog.isQuiet() {
+		return
+	}
+	progressLog.log(LevelInfo, fields, format, args...)
+}
+
+func (l *logger) isQuiet() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.quiet
+}
+
+// progressCounter numbers submissions as workers pick them up, so progress
+// reads [3/40], [4/40], ... however the workers interleave.
+type progressCounter struct {
+	mu    sync.Mutex
+	n     int
+	total int
+}
+
+// start logs that the next submission is being graded.
+func (p *progressCounter) start(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n++
+	logProgress(logFields{"submission": name, "n": p.n, "total": p.total}, "[%d/%d] grading %s...", p.n, p.total, name)
+}
+
+func logWarn(fields logFields, format string, args ...interface{}) {
+	progressLog.log(LevelWarn, fields, format, args...)
+}
+
+func logError(fields logFields, format string, args ...interface{}) {
+	progressLog.log(LevelError, fields, format, args...)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the test case duration
+// histogram.
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// gradingMetrics tallies graded submissions for --metrics-addr, in the
+// Prometheus text format.
+type gradingMetrics struct {
+	mu          sync.Mutex
+	submitted   int
+	compiled    int
+	passed      int
+	failed      int
+	timedOut    int
+	durations   []int // per bucket, not cumulative
+	durationN   int
+	durationSum float64
+}
+
+var metrics = &gradingMetrics{durations: make([]int, len(durationBuckets))}
+
+// observe adds a graded submission to the tallies.
+func (m *gradingMetrics) observe(sub *Submission) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.submitted++
+	if sub.Compiled() {
+		m.compiled++
+	}
+	passed, failed, timeout := caseCounts(sub)
+	m.passed += passed
+	m.failed += failed
+	m.timedOut += timeout
+
+	for _, res := range sub.RunResults {
+		if res.Status == STATUS_SKIPPED {
+			continue
+		}
+		secs := res.Duration.Seconds()
+		for i, le := range durationBuckets {
+			if secs <= le {
+				m.durations[i]++
+				break
+			}
+		}
+		m.durationN++
+		m.durationSum += secs
+	}
+}
+
+func (m *gradingMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	counter := func(name, help string, v int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+	counter("submissions_total", "Submissions graded.", m.submitted)
+	counter("submissions_compiled", "Submissions graded that compiled.", m.compiled)
+	counter("testcases_passed_total", "Test cases that passed.", m.passed)
+	counter("testcases_failed_total", "Test cases that failed for any reason but a timeout.", m.failed)
+	counter("testcases_timeout_total", "Test cases that timed out.", m.timedOut)
+
+	name := "testcase_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s How long test case runs took.\n# TYPE %s histogram\n", name, name)
+	cumulative := 0
+	for i, le := range durationBuckets {
+		cumulative += m.durations[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, m.durationN)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, m.durationSum, name, m.durationN)
+}
+
+// serveMetrics starts serving the metrics at addr under /metrics. The server
+// runs until it is closed.
+func serveMetrics(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	logInfo(logFields{"addr": ln.Addr().String()}, "Serving metrics on http://%s/metrics", ln.Addr())
+	return srv, nil
+}
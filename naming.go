@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Naming schemes for --naming, which say where in a submission's filename
+// its Java class name is
+const (
+	NamingCanvas = "canvas" // <name>_<id>_<id>_<Class>[-<n>].java
+	NamingPlain  = "plain"  // <Class>.java
+)
+
+var (
+	javaIdent   = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+	publicClass = regexp.MustCompile(`(?m)^\s*public\s+(?:(?:final|abstract)\s+)*class\s+([A-Za-z_$][A-Za-z0-9_$]*)`)
+	// Nested classes are indented, so a class at the start of a line is
+	// taken to be a top-level one
+	topLevelClass = regexp.MustCompile(`(?m)^(?:(?:final|abstract)\s+)*class\s+([A-Za-z_$][A-Za-z0-9_$]*)`)
+)
+
+func validNaming(naming string) error {
+	if naming != NamingCanvas && naming != NamingPlain {
+		return fmt.Errorf("unknown naming scheme %q (want %s or %s)", naming, NamingCanvas, NamingPlain)
+	}
+	return nil
+}
+
+// className is the class named in a submission's filename under the naming
+// scheme. ok is false if the filename doesn't follow the scheme.
+func className(path, naming string) (class string, ok bool) {
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if naming == NamingCanvas {
+		raw := strings.Split(stem, "_")
+		if len(raw) < 4 {
+			return "", false
+		}
+		// Canvas appends -1, -2, ... to resubmissions
+		stem = strings.Split(strings.Join(raw[3:], ""), "-")[0]
+	}
+	return stem, javaIdent.MatchString(stem)
+}
+
+// javaClass is the class to compile a single-file Java submission as: the
+// one declared in the source, since students often name the file something
+// else. If the source can't be read the filename decides, and if that
+// doesn't follow the naming scheme either there is nothing to go on, so the
+// submission is skipped with an error saying why.
+func javaClass(path, naming string) (string, error) {
+	if class, ok := sourceClass(path); ok {
+		return class, nil
+	}
+	if class, ok := className(path, naming); ok {
+		return class, nil
+	}
+	return "", fmt.Errorf("%s declares no class and its name doesn't follow --naming %s (%s), so there is no class to compile it as",
+		filepath.Base(path), naming, namingFormat(naming))
+}
+
+// namingFormat describes the filenames a naming scheme expects.
+func namingFormat(naming string) string {
+	if naming == NamingCanvas {
+		return "<name>_<id>_<id>_<Class>.java, with underscores"
+	}
+	return "<Class>.java"
+}
+
+// sourceClass reads the name of the public class declared in a Java file, or
+// of its first top-level class if none is public.
+func sourceClass(path string) (class string, ok bool) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	m := publicClass.FindSubmatch(src)
+	if m == nil {
+		m = topLevelClass.FindSubmatch(src)
+	}
+	if m == nil {
+		return "", false
+	}
+	return string(m[1]), true
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClassName(t *testing.T) {
+	tests := []struct {
+		path   string
+		naming string
+		class  string
+		ok     bool
+	}{
+		{"smith_1234_5678_Echo.java", NamingCanvas, "Echo", true},
+		{"subs/smith_1234_5678_Echo.java", NamingCanvas, "Echo", true},
+		{"smith_1234_5678_Echo-1.java", NamingCanvas, "Echo", true},
+		{"smith_1234_5678_My_Echo.java", NamingCanvas, "MyEcho", true},
+		{"smith-1234-5678-Echo.java", NamingCanvas, "", false},
+		{"smith_1234_Echo.java", NamingCanvas, "", false},
+		{"smith_1234_5678_2Echo.java", NamingCanvas, "2Echo", false},
+		{"Foo.java", NamingPlain, "Foo", true},
+		{"dir/Foo.java", NamingPlain, "Foo", true},
+		{"smith_1234_5678_Echo.java", NamingPlain, "smith_1234_5678_Echo", true},
+		{"my-program.java", NamingPlain, "my-program", false},
+	}
+	for _, tt := range tests {
+		class, ok := className(tt.path, tt.naming)
+		if ok != tt.ok || (ok && class != tt.class) {
+			t.Errorf("className(%q, %s) = %q, %v, want %q, %v", tt.path, tt.naming, class, ok, tt.class, tt.ok)
+		}
+	}
+}
+
+func TestJavaClass(t *testing.T) {
+	tests := []struct {
+		name   string
+		naming string
+		source string
+		class  string // "" if it should be an error
+	}{
+		{"smith_1234_5678_Echo.java", NamingCanvas, "public class Main {}\n", "Main"},
+		{"smith_1234_5678_Echo.java", NamingCanvas, "class Helper {}\n", "Helper"},
+		{"smith_1234_5678_Echo.java", NamingCanvas, "interface Shape {}\n", "Echo"},
+		{"Foo.java", NamingPlain, "interface Shape {}\n", "Foo"},
+		{"smith-1234-Echo.java", NamingCanvas, "interface Shape {}\n", ""},
+		{"my-program.java", NamingPlain, "interface Shape {}\n", ""},
+		{"my-program.java", NamingPlain, "public final class Program {}\n", "Program"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		writeFile(t, path, tt.source, 0644)
+		class, err := javaClass(path, tt.naming)
+		if tt.class == "" {
+			if err == nil {
+				t.Errorf("javaClass(%s, %s) = %q, want an error", tt.name, tt.naming, class)
+			} else if !strings.Contains(err.Error(), tt.name) || !strings.Contains(err.Error(), namingFormat(tt.naming)) {
+				t.Errorf("javaClass(%s, %s) error %q doesn't name the file and the expected format", tt.name, tt.naming, err)
+			}
+			continue
+		}
+		if err != nil || class != tt.class {
+			t.Errorf("javaClass(%s, %s) = %q, %v, want %q", tt.name, tt.naming, class, err, tt.class)
+		}
+	}
+}
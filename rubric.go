@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RubricCriterion groups test cases under one line of the grading rubric.
+// Cases are named by their expected-output filename without ".out", and "*"
+// matches every case. Check picks how the criterion is summarized:
+//
+//	"count" (default)  "Correctness: 18/20"
+//	"all"              "Handles edge cases: passed" only if every case passed
+//	"time"             "Performance: within limits" unless a case timed out
+type RubricCriterion struct {
+	Name  string   `json:"name"`
+	Cases []string `json:"cases"`
+	Check string   `json:"check"`
+}
+
+// loadRubric reads a JSON list of criteria, e.g.
+//
+//	[{"name": "Correctness (hidden cases)", "cases": ["*"]},
+//	 {"name": "Handles edge cases", "cases": ["empty", "huge"], "check": "all"},
+//	 {"name": "Performance", "cases": ["huge"], "check": "time"}]
+func loadRubric(path string) ([]*RubricCriterion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rubric := make([]*RubricCriterion, 0)
+	err = json.Unmarshal(data, &rubric)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rubric %s: %w", path, err)
+	}
+
+	for _, crit := range rubric {
+		switch crit.Check {
+		case "", "count", "all", "time":
+		default:
+			return nil, fmt.Errorf("invalid rubric %s: criterion %q has unknown check %q", path, crit.Name, crit.Check)
+		}
+	}
+	return rubric, nil
+}
+
+func caseName(outPath string) string {
+	name := trimCompressedExt(outPath)
+	if stem, _, ok := altOutput(name); ok {
+		return filepath.Base(stem)
+	}
+	return strings.TrimSuffix(filepath.Base(name), ".out")
+}
+
+func (c *RubricCriterion) includes(name string) bool {
+	for _, pattern := range c.Cases {
+		if pattern == "*" || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// summary describes how the submission did on this criterion's cases.
+func (c *RubricCriterion) summary(results []*Result) string {
+	passed := 0
+	timedOut := 0
+	for _, res := range results {
+		if res.Passed() {
+			passed++
+		}
+		if res.Status == STATUS_TIMEOUT {
+			timedOut++
+		}
+	}
+
+	switch c.Check {
+	case "all":
+		if passed == len(results) {
+			return "passed"
+		}
+		return fmt.Sprintf("not passed (%d/%d)", passed, len(results))
+	case "time":
+		if timedOut == 0 {
+			return "within limits"
+		}
+		return fmt.Sprintf("%d case(s) timed out", timedOut)
+	}
+	return fmt.Sprintf("%d/%d", passed, len(results))
+}
+
+// writeRubricCases writes an overview line per criterion, then the details
+// of each criterion's cases. Cases not covered by the rubric are listed last.
+func writeRubricCases(f *bytes.Buffer, cases []TestCase, sub *Submission, cfg *Config) {
+	rubric := cfg.rubric
+	covered := make([]bool, len(sub.RunResults))
+	grouped := make([][]int, len(rubric))
+	for ci, crit := range rubric {
+		for i := range sub.RunResults {
+			if crit.includes(caseName(cases[i].Out)) {
+				grouped[ci] = append(grouped[ci], i)
+				covered[i] = true
+			}
+		}
+	}
+
+	f.WriteString("Rubric:\n")
+	for ci, crit := range rubric {
+		results := make([]*Result, 0, len(grouped[ci]))
+		for _, i := range grouped[ci] {
+			results = append(results, sub.RunResults[i])
+		}
+		f.WriteString(fmt.Sprintf("  %s: %s\n", crit.Name, crit.summary(results)))
+	}
+
+	for ci, crit := range rubric {
+		f.WriteString(fmt.Sprintf("\n==================%s==================\n", crit.Name))
+		for _, i := range grouped[ci] {
+			writeCase(f, cases[i].Out, sub.RunResults[i], cfg)
+		}
+	}
+
+	header := false
+	for i, res := range sub.RunResults {
+		if covered[i] {
+			continue
+		}
+		if !header {
+			f.WriteString("\n==================Other Cases==================\n")
+			header = true
+		}
+		writeCase(f, cases[i].Out, res, cfg)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+)
+
+// Sandboxes accepted by --sandbox
+const (
+	SandboxNone   = "none"
+	SandboxDocker = "docker"
+	SandboxUser   = "user"
+)
+
+// DefaultSandboxMemory is the container memory limit when the run has none
+// of its own, in bytes.
+const DefaultSandboxMemory = 256 << 20
+
+// Executor starts the programs runExec runs, either directly or in a
+// sandbox.
+type Executor interface {
+	// Command returns the process that runs command inside dir, and a
+	// function that kills it along with anything it started. The caller
+	// connects its input and output.
+	Command(dir string, command []string, limits RunLimits) (cmd *exec.Cmd, kill func())
+
+	// sharesStdin reports whether the program reads the input file it is
+	// given directly, so the file's offset afterwards shows how much of it
+	// was read, rather than through something copying it in.
+	sharesStdin() bool
+
+	// measuresMemory reports whether the rusage of the process Command
+	// returns covers the program itself, so its peak memory means something.
+	measuresMemory() bool
+}
+
+// localExecutor runs programs directly, each in a process group of its own
+// so killing it takes any children along.
+type localExecutor struct{}
+
+func (localExecutor) Command(dir string, command []string, limits RunLimits) (*exec.Cmd, func()) {
+	command = withMemoryLimit(command, limits.MaxMemoryBytes)
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd, func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+}
+
+func (localExecutor) sharesStdin() bool { return true }
+
+func (localExecutor) measuresMemory() bool { return true }
+
+// dockerExecutor runs each program in a throwaway container with no
+// network, one CPU and capped memory and process counts. Only the test
+// folder is mounted, read-only and at the same path as outside, so {dir} in
+// language commands still points at it. The program's input comes in on
+// stdin, and /tmp is the only place it can write.
+type dockerExecutor struct {
+	Image string
+}
+
+var containerCount int64
+
+func (e dockerExecutor) Command(dir string, command []string, limits RunLimits) (*exec.Cmd, func()) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	memory := limits.containerMemory
+	if memory <= 0 {
+		memory = DefaultSandboxMemory
+	}
+	name := fmt.Sprintf("submissioncheck-%d-%d", os.Getpid(), atomic.AddInt64(&containerCount, 1))
+
+	args := []string{
+		"run", "--rm", "-i", "--name", name,
+		"--network", "none",
+		"--memory", strconv.FormatInt(memory, 10),
+		"--cpus", "1",
+		"--pids-limit", "64",
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"--read-only",
+		"--tmpfs", "/tmp",
+		"--volume", abs + ":" + abs + ":ro",
+		"--workdir", abs,
+		e.Image,
+	}
+	cmd := exec.Command("docker", append(args, command...)...)
+	cmd.Dir = dir
+	return cmd, func() {
+		// Killing the docker client would leave the container running
+		exec.Command("docker", "kill", name).Run()
+		cmd.Process.Kill()
+	}
+}
+
+// The docker client reads the input itself and pipes it into the container,
+// whether the program wants it or not.
+func (dockerExecutor) sharesStdin() bool { return false }
+
+// The program runs under the docker daemon, so the client's own rusage is
+// all Wait sees.
+func (dockerExecutor) measuresMemory() bool { return false }
+
+// userExecutor runs each program as an unprivileged user through sudo, which
+// has to let this user do so without a password. The program can read its
+// test folder but not write to it, or to anything else of ours. There is no
+// CPU limit, but --max-memory still applies.
+type userExecutor struct {
+	User string
+}
+
+func (e userExecutor) Command(dir string, command []string, limits RunLimits) (*exec.Cmd, func()) {
+	command = withMemoryLimit(command, limits.MaxMemoryBytes)
+	cmd := exec.Command("sudo", append([]string{"-n", "-u", e.User, "--"}, command...)...)
+	cmd.Dir = dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd, func() {
+		// Only the other user, or root, may signal what runs as them
+		pgid := strconv.Itoa(cmd.Process.Pid)
+		exec.Command("sudo", "-n", "-u", e.User, "--", "kill", "-KILL", "--", "-"+pgid).Run()
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// sudo hands the program its own stdin.
+func (userExecutor) sharesStdin() bool { return true }
+
+// sudo waits for the program, so its rusage takes in the program's.
+func (userExecutor) measuresMemory() bool { return true }
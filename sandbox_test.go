@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDockerKeepsJavaMemoryLimit(t *testing.T) {
+	cfg := testConfig(t, "--sandbox", "docker", "--sandbox-image", "openjdk:17", "--max-memory", "1g")
+	limits := cfg.limits()
+	command := append(javaCommand(nil, &limits), "Main")
+	cmd, _ := limits.executor.Command(t.TempDir(), command, limits)
+	args := strings.Join(cmd.Args, " ")
+
+	if !strings.Contains(args, " --memory 1073741824 ") {
+		t.Errorf("container doesn't get the 1g limit: %s", args)
+	}
+	if !strings.HasSuffix(args, " openjdk:17 java -Xmx1048576k Main") {
+		t.Errorf("JVM doesn't get the 1g heap: %s", args)
+	}
+}
+
+func TestDockerDefaultMemoryLimit(t *testing.T) {
+	cfg := testConfig(t, "--sandbox", "docker")
+	limits := cfg.limits()
+	command := append(javaCommand(nil, &limits), "Main")
+	cmd, _ := limits.executor.Command(t.TempDir(), command, limits)
+	args := strings.Join(cmd.Args, " ")
+
+	if !strings.Contains(args, " --memory "+strconv.Itoa(DefaultSandboxMemory)+" ") {
+		t.Errorf("container doesn't get the default limit: %s", args)
+	}
+	if strings.Contains(args, "-Xmx") {
+		t.Errorf("JVM gets a heap limit without --max-memory: %s", args)
+	}
+}
+
+func TestDockerKilledContainerIsOutOfMemory(t *testing.T) {
+	// What the docker client exits with when the container is OOM-killed
+	err := exec.Command("sh", "-c", "exit 137").Run()
+	tests := []struct {
+		executor Executor
+		want     Status
+	}{
+		{dockerExecutor{}, STATUS_MEMORY},
+		{localExecutor{}, STATUS_RUNTIME_ERR},
+	}
+	for _, tt := range tests {
+		status, _ := exitStatus(err, "", RunLimits{executor: tt.executor})
+		if status != tt.want {
+			t.Errorf("%T: exit 137 is %v, want %v", tt.executor, status, tt.want)
+		}
+	}
+}
+
+func TestDockerSkipsPeakMemory(t *testing.T) {
+	// Stands in for docker: drops everything up to the image and runs the
+	// rest, as the client would inside the container
+	bin := t.TempDir()
+	writeFile(t, filepath.Join(bin, "docker"), `#!/bin/sh
+[ "$1" = kill ] && exit 0
+shift
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--rm|-i|--read-only) shift;;
+	--*) shift 2;;
+	*) shift; break;;
+	esac
+done
+exec "$@"
+`, 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "1.in"), "", 0644)
+	limits := RunLimits{Timeout: 10, MaxPeakKB: 1, executor: dockerExecutor{Image: "img"}}
+	res, err := runExec(context.Background(), dir, []string{"sh", "-c", "echo hi"}, filepath.Join(dir, "1.in"), limits)
+	if err != nil {
+		t.Fatalf("runExec: %v", err)
+	}
+	if res.Status != STATUS_OK || res.MemoryKB != 0 {
+		t.Errorf("got %v with %d KB, want %v with no peak memory (stderr %q)", res.Status, res.MemoryKB, STATUS_OK, res.Err())
+	}
+
+	limits.executor = localExecutor{}
+	res, err = runExec(context.Background(), dir, []string{"sh", "-c", "echo hi"}, filepath.Join(dir, "1.in"), limits)
+	if err != nil {
+		t.Fatalf("runExec: %v", err)
+	}
+	if res.Status != STATUS_MEMORY_EXCEEDED || res.MemoryKB == 0 {
+		t.Errorf("locally got %v with %d KB, want %v", res.Status, res.MemoryKB, STATUS_MEMORY_EXCEEDED)
+	}
+}
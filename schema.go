@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Schema is a lightweight description of what a program's output should look
+// like, checked before diffing so badly formatted output gets a clear message
+// instead of a confusing character diff.
+//
+// It is parsed from a comma separated list of rules, e.g. "lines=5,each=int":
+//
+//	lines=<n>         output must have exactly n lines
+//	lines=expected    output must have as many lines as the expected output
+//	tokens=<n>        every line must have exactly n whitespace separated tokens
+//	each=<type>       every token must be an int, float, or word (non-numeric)
+type Schema struct {
+	Lines             int
+	LinesFromExpected bool
+	TokensPerLine     int
+	TokenType         string
+}
+
+func parseSchema(spec string) (*Schema, error) {
+	schema := &Schema{}
+	for _, rule := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("schema rule %q must look like key=value", rule)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		var err error
+		switch key {
+		case "lines":
+			if value == "expected" {
+				schema.LinesFromExpected = true
+			} else {
+				schema.Lines, err = strconv.Atoi(value)
+			}
+		case "tokens":
+			schema.TokensPerLine, err = strconv.Atoi(value)
+		case "each":
+			switch value {
+			case "int", "float", "word":
+				schema.TokenType = value
+			default:
+				err = fmt.Errorf("unknown token type %q (want int, float, or word)", value)
+			}
+		default:
+			err = fmt.Errorf("unknown schema rule %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema rule %q: %w", rule, err)
+		}
+	}
+	return schema, nil
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+// check returns a description of the first way actual violates the schema,
+// or nil if it conforms.
+func (s *Schema) check(expected, actual string) error {
+	actual = strings.ReplaceAll(actual, "\r", "")
+	lines := splitLines(actual)
+
+	wantLines := s.Lines
+	if s.LinesFromExpected {
+		wantLines = len(splitLines(strings.ReplaceAll(expected, "\r", "")))
+	}
+	if (wantLines > 0 || s.LinesFromExpected) && len(lines) != wantLines {
+		return fmt.Errorf("expected %d line(s), got %d", wantLines, len(lines))
+	}
+
+	for i, line := range lines {
+		tokens := strings.Fields(line)
+		if s.TokensPerLine > 0 && len(tokens) != s.TokensPerLine {
+			return fmt.Errorf("line %d: expected %d token(s), got %d", i+1, s.TokensPerLine, len(tokens))
+		}
+
+		for _, token := range tokens {
+			if !tokenHasType(token, s.TokenType) {
+				return fmt.Errorf("line %d: expected %s, got %q", i+1, s.TokenType, token)
+			}
+		}
+	}
+	return nil
+}
+
+func tokenHasType(token, tokenType string) bool {
+	switch tokenType {
+	case "int":
+		_, err := strconv.ParseInt(token, 10, 64)
+		return err == nil
+	case "float":
+		_, err := strconv.ParseFloat(token, 64)
+		return err == nil
+	case "word":
+		_, err := strconv.ParseFloat(token, 64)
+		return err != nil
+	}
+	return true
+}
+
+// checkFormat validates actual against the configured schema, if any.
+func checkFormat(expected, actual string, cfg *Config) error {
+	if cfg.schema == nil {
+		return nil
+	}
+	return cfg.schema.check(expected, actual)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxUploadBytes caps the size of a file POSTed to --serve.
+const MaxUploadBytes = 32 << 20
+
+// Finished jobs are forgotten FinishedJobTTL after they are graded, or
+// sooner once there are more than MaxFinishedJobs of them, so a long-running
+// server doesn't keep every report in memory.
+const (
+	FinishedJobTTL  = time.Hour
+	MaxFinishedJobs = 1000
+)
+
+// MaxPendingJobs caps how many uploads can be queued or grading at once.
+// Past it, new ones are turned away until some finish.
+const MaxPendingJobs = 100
+
+// Job states reported by GET /result/<id>
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+)
+
+// gradeJob is a submission uploaded to the server.
+type gradeJob struct {
+	ID     string      `json:"id"`
+	Status string      `json:"status"`
+	Report *jsonReport `json:"report,omitempty"`
+
+	finished time.Time
+}
+
+// gradeServer takes submissions over HTTP and grades them in the background,
+// on up to cfg.Workers at once.
+type gradeServer struct {
+	ctx       context.Context
+	mu        sync.Mutex
+	jobs      map[string]*gradeJob
+	finished  []*gradeJob // jobs that are done, oldest first
+	pending   int         // jobs queued or grading
+	uploadDir string
+	slots     chan struct{}
+	grading   sync.WaitGroup
+
+	cases    []TestCase
+	timeouts map[string]int
+	cfg      *Config
+	namer    *dirNamer
+}
+
+// serveSubmissions grades submissions POSTed to addr until ctx is cancelled,
+// then waits for the jobs in progress, which are cut short. Uploads are kept
+// in uploadDir while they are graded.
+func serveSubmissions(ctx context.Context, addr, uploadDir string, cases []TestCase, timeouts map[string]int, cfg *Config, namer *dirNamer) error {
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	s := &gradeServer{
+		ctx:       ctx,
+		jobs:      make(map[string]*gradeJob),
+		uploadDir: uploadDir,
+		slots:     make(chan struct{}, workers),
+		cases:     cases,
+		timeouts:  timeouts,
+		cfg:       cfg,
+		namer:     namer,
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/submit", s.submit)
+	mux.HandleFunc("/result/", s.result)
+	logInfo(logFields{"addr": ln.Addr().String()}, "Accepting submissions on http://%s/submit", ln.Addr())
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	err = srv.Serve(ln)
+	if err != http.ErrServerClosed {
+		return err
+	}
+	s.grading.Wait()
+	logInfo(nil, "Stopped accepting submissions.")
+	return nil
+}
+
+// submit takes a multipart upload with the submission in its "file" field,
+// named the way it would be in the submissions folder, and answers with the
+// ID to fetch its result by.
+func (s *gradeServer) submit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "submissions must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Turned away before the upload is read, so a flood of them costs
+	// nothing. The place is given back unless a job gets it.
+	s.mu.Lock()
+	full := s.pending >= MaxPendingJobs
+	if !full {
+		s.pending++
+	}
+	s.mu.Unlock()
+	if full {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, fmt.Sprintf("too many submissions waiting to be graded (%d), try again later", MaxPendingJobs), http.StatusServiceUnavailable)
+		return
+	}
+	queued := false
+	defer func() {
+		if !queued {
+			s.mu.Lock()
+			s.pending--
+			s.mu.Unlock()
+		}
+	}()
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadBytes)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "expected a multipart form with the submission in a \"file\" field: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(header.Filename, "\\", "/")))
+	if name == "/" || name == "." || filepath.Ext(name) == MetaExt {
+		http.Error(w, "the uploaded file needs a name", http.StatusBadRequest)
+		return
+	}
+	// Uploads aren't executable, so they need a language to run them with
+	if _, ok := s.cfg.exts[filepath.Ext(name)]; !ok && s.cfg.Language == "" {
+		http.Error(w, "no language is set up for files like "+name, http.StatusBadRequest)
+		return
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	path, err := s.save(id, name, file)
+	if err != nil {
+		logError(logFields{"job": id, "error": err}, "could not save upload %s: %v", name, err)
+		http.Error(w, "could not save the upload", http.StatusInternalServerError)
+		return
+	}
+
+	job := &gradeJob{ID: id, Status: JobQueued}
+	reply := *job
+	s.mu.Lock()
+	s.jobs[id] = job
+	s.mu.Unlock()
+	logInfo(logFields{"job": id, "submission": name}, "Received %s as job %s", name, id)
+	s.grading.Add(1)
+	queued = true
+	go s.grade(job, path)
+
+	w.Header().Set("Location", "/result/"+id)
+	writeJSON(w, http.StatusAccepted, reply)
+}
+
+// result answers with the job's report once it is graded, and 202 with its
+// status until then.
+func (s *gradeServer) result(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "results must be fetched with GET", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/result/")
+	s.mu.Lock()
+	s.forgetFinished(time.Now())
+	job, ok := s.jobs[id]
+	var reply gradeJob
+	if ok {
+		reply = *job
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no such job (results are kept for %v after grading, and only the latest %d of them)", FinishedJobTTL, MaxFinishedJobs), http.StatusNotFound)
+		return
+	}
+
+	if reply.Status != JobDone {
+		writeJSON(w, http.StatusAccepted, reply)
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// save writes the upload into a folder of its own, so uploads with the
+// same name don't clash.
+func (s *gradeServer) save(id, name string, file io.Reader) (string, error) {
+	dir := filepath.Join(s.uploadDir, id)
+	err := os.Mkdir(dir, 0777)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, file)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return path, err
+}
+
+// grade waits for a free worker, then grades the upload at path the same
+// way a full run grades each submission.
+func (s *gradeServer) grade(job *gradeJob, path string) {
+	defer s.grading.Done()
+	defer scratch.sweepOnPanic()
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+	defer os.RemoveAll(filepath.Dir(path))
+	s.setStatus(job, JobRunning, nil)
+
+	subs, _ := runSubmissions(s.ctx, []string{path}, s.cases, s.timeouts, s.cfg, s.namer)
+	sub := subs[0]
+	err := gradeSubmission(sub, s.cases, s.cfg)
+	if err != nil {
+		logError(logFields{"job": job.ID, "error": err}, "could not grade job %s: %v", job.ID, err)
+		sub = failedSubmission(path, s.cases, err)
+	}
+	applyLatePenalty(sub, s.cfg)
+	logVerdicts(sub, s.cases)
+	metrics.observe(sub)
+	err = history.record(s.cases, []*Submission{sub})
+	if err != nil {
+		logWarn(logFields{"db": s.cfg.DB, "error": err}, "could not save results to %s: %v", s.cfg.DB, err)
+	}
+
+	s.setStatus(job, JobDone, newJSONReport(s.cases, sub))
+	logInfo(logFields{"job": job.ID, "submission": sub.Name, "score": sub.Score}, "Job %s (%s) scored %.2f%%", job.ID, sub.Name, sub.Score)
+}
+
+func (s *gradeServer) setStatus(job *gradeJob, status string, report *jsonReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.Status = status
+	job.Report = report
+	if status == JobDone {
+		s.pending--
+		job.finished = time.Now()
+		s.finished = append(s.finished, job)
+		s.forgetFinished(job.finished)
+	}
+}
+
+// forgetFinished drops finished jobs that are past FinishedJobTTL, and the
+// oldest ones over MaxFinishedJobs. s.mu must be held.
+func (s *gradeServer) forgetFinished(now time.Time) {
+	for len(s.finished) != 0 {
+		oldest := s.finished[0]
+		if len(s.finished) <= MaxFinishedJobs && now.Sub(oldest.finished) < FinishedJobTTL {
+			break
+		}
+		delete(s.jobs, oldest.ID)
+		s.finished[0] = nil
+		s.finished = s.finished[1:]
+	}
+}
+
+// newJobID makes an ID that can't be guessed, since it is all it takes to
+// read a report.
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(data, '\n'))
+}
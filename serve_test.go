@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestFinishedJobsAreForgotten(t *testing.T) {
+	s := &gradeServer{jobs: make(map[string]*gradeJob)}
+	status := func(id string) int {
+		rec := httptest.NewRecorder()
+		s.result(rec, httptest.NewRequest(http.MethodGet, "/result/"+id, nil))
+		return rec.Code
+	}
+
+	old := &gradeJob{ID: "old", Status: JobQueued}
+	running := &gradeJob{ID: "running", Status: JobQueued}
+	s.jobs[old.ID] = old
+	s.jobs[running.ID] = running
+	s.setStatus(old, JobDone, nil)
+	s.setStatus(running, JobRunning, nil)
+	if got := status("old"); got != http.StatusOK {
+		t.Fatalf("finished job: got %d, want %d", got, http.StatusOK)
+	}
+
+	// Past its time, the finished job goes but the running one stays
+	s.mu.Lock()
+	old.finished = old.finished.Add(-FinishedJobTTL)
+	s.mu.Unlock()
+	if got := status("old"); got != http.StatusNotFound {
+		t.Errorf("expired job: got %d, want %d", got, http.StatusNotFound)
+	}
+	if got := status("running"); got != http.StatusAccepted {
+		t.Errorf("running job: got %d, want %d", got, http.StatusAccepted)
+	}
+
+	// Only the newest MaxFinishedJobs are kept
+	for i := 0; i <= MaxFinishedJobs; i++ {
+		job := &gradeJob{ID: strconv.Itoa(i)}
+		s.jobs[job.ID] = job
+		s.setStatus(job, JobDone, nil)
+	}
+	if got := status("0"); got != http.StatusNotFound {
+		t.Errorf("oldest of %d finished jobs: got %d, want %d", MaxFinishedJobs+1, got, http.StatusNotFound)
+	}
+	if got := status(strconv.Itoa(MaxFinishedJobs)); got != http.StatusOK {
+		t.Errorf("newest finished job: got %d, want %d", got, http.StatusOK)
+	}
+	if len(s.jobs) != MaxFinishedJobs+1 {
+		t.Errorf("kept %d jobs, want %d finished and 1 running", len(s.jobs), MaxFinishedJobs)
+	}
+}
+
+func TestSubmitRefusesPastPendingLimit(t *testing.T) {
+	s := &gradeServer{jobs: make(map[string]*gradeJob), pending: MaxPendingJobs}
+	post := func() int {
+		rec := httptest.NewRecorder()
+		s.submit(rec, httptest.NewRequest(http.MethodPost, "/submit", nil))
+		return rec.Code
+	}
+
+	if got := post(); got != http.StatusServiceUnavailable {
+		t.Errorf("with %d jobs pending: got %d, want %d", MaxPendingJobs, got, http.StatusServiceUnavailable)
+	}
+
+	// A rejected upload gives its place back
+	s.pending = MaxPendingJobs - 1
+	if got := post(); got != http.StatusBadRequest {
+		t.Errorf("upload without a file: got %d, want %d", got, http.StatusBadRequest)
+	}
+	if s.pending != MaxPendingJobs-1 {
+		t.Errorf("%d jobs pending after a bad upload, want %d", s.pending, MaxPendingJobs-1)
+	}
+}
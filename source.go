@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MaxSourceBytes caps how much of each file --include-source puts in a
+// report.
+const MaxSourceBytes = 256 << 10
+
+// SourceFile is one of a submission's files, as it was compiled or run.
+type SourceFile struct {
+	Name      string
+	Text      string
+	Truncated bool
+}
+
+// readSources reads the files in a test folder that was just set up, which
+// are the submission's own. Binaries (anything with a NUL byte early on)
+// are left out.
+func readSources(dir string) ([]SourceFile, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sources := make([]SourceFile, 0)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		head := data
+		if len(head) > 8000 {
+			head = head[:8000]
+		}
+		if bytes.IndexByte(head, 0) >= 0 {
+			continue
+		}
+
+		src := SourceFile{Name: filepath.ToSlash(name)}
+		if len(data) > MaxSourceBytes {
+			data = data[:MaxSourceBytes]
+			src.Truncated = true
+		}
+		src.Text = strings.ReplaceAll(string(data), "\r", "")
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// sourceLanguage is the language a source file is highlighted as in HTML
+// reports, or "" for none.
+func sourceLanguage(name string) string {
+	switch filepath.Ext(name) {
+	case ".java":
+		return "java"
+	case ".c", ".h":
+		return "c"
+	case ".cpp", ".cc", ".cxx", ".hpp":
+		return "cpp"
+	case ".py":
+		return "python"
+	case ".kt":
+		return "kotlin"
+	}
+	return ""
+}
+
+// writeSources adds the submission's files to the end of its text report.
+func writeSources(f *bytes.Buffer, sub *Submission) {
+	for _, src := range sub.Sources {
+		f.WriteString(fmt.Sprintf("---------------Source: %s---------------\n", src.Name))
+		f.WriteString(src.Text)
+		if !strings.HasSuffix(src.Text, "\n") {
+			f.WriteString("\n")
+		}
+		if src.Truncated {
+			f.WriteString(fmt.Sprintf("... (cut off after %d bytes)\n", MaxSourceBytes))
+		}
+		f.WriteString("\n")
+	}
+}
+
+// htmlHighlight colors the <code data-lang> blocks of an HTML report. It
+// only marks comments, strings, numbers and keywords, which is all a grader
+// skimming the code needs, and builds DOM nodes from the text so the source
+// can't inject anything.
+const htmlHighlight = `<script>
+(function () {
+  var cStyle = "//.*|/\\*[\\s\\S]*?\\*/";
+  var langs = {
+    java: [cStyle, "abstract assert boolean break byte case catch char class continue default do double else enum extends final finally float for if implements import instanceof int interface long native new null package private protected public return short static super switch synchronized this throw throws try var void volatile while true false"],
+    c: [cStyle, "auto break case char const continue default do double else enum extern float for goto if inline int long register return short signed sizeof static struct switch typedef union unsigned void volatile while NULL"],
+    cpp: [cStyle, "auto bool break case catch char class const continue default delete do double else enum explicit extern false float for friend if inline int long namespace new nullptr operator private protected public return short signed sizeof static struct switch template this throw true try typedef typename union unsigned using virtual void volatile while"],
+    kotlin: [cStyle, "as break class continue data do else false for fun if import in interface is null object package private protected public return sealed super this throw true try typealias val var when while"],
+    python: ["#.*", "and as assert break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return True try while with yield"]
+  };
+  var blocks = document.querySelectorAll("code[data-lang]");
+  for (var i = 0; i < blocks.length; i++) {
+    var code = blocks[i], lang = langs[code.getAttribute("data-lang")];
+    if (!lang) continue;
+    var words = lang[1].split(" ").join("|");
+    var re = new RegExp("(" + lang[0] + ")|(\"(?:\\\\.|[^\"\\\\\\n])*\"|'(?:\\\\.|[^'\\\\\\n])*')|\\b(\\d+(?:\\.\\d+)?)\\b|\\b(" + words + ")\\b", "g");
+    var text = code.textContent, out = document.createDocumentFragment(), last = 0, m;
+    while ((m = re.exec(text)) !== null) {
+      out.appendChild(document.createTextNode(text.slice(last, m.index)));
+      var span = document.createElement("span");
+      span.className = m[1] ? "tok-comment" : m[2] ? "tok-string" : m[3] ? "tok-number" : "tok-keyword";
+      span.textContent = m[0];
+      out.appendChild(span);
+      last = re.lastIndex;
+    }
+    out.appendChild(document.createTextNode(text.slice(last)));
+    code.textContent = "";
+    code.appendChild(out);
+  }
+})();
+</script>`
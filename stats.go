@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const HistogramBarWidth = 50
+
+// NearTimeoutFraction is how close to the limit a completed case has to run
+// to count as "just under" the timeout.
+const NearTimeoutFraction = 0.8
+
+type histogramBucket struct {
+	Low   float64
+	High  float64
+	Count int
+}
+
+// scoreHistogram splits 0-100% into numBuckets equal ranges and counts how
+// many submission scores fall into each. A perfect score lands in the last
+// bucket.
+func scoreHistogram(scores []float64, numBuckets int) []histogramBucket {
+	if numBuckets < 1 {
+		numBuckets = 1
+	}
+
+	width := 100 / float64(numBuckets)
+	buckets := make([]histogramBucket, numBuckets)
+	for i := range buckets {
+		buckets[i].Low = width * float64(i)
+		buckets[i].High = width * float64(i+1)
+	}
+
+	for _, score := range scores {
+		i := int(score / width)
+		if i >= numBuckets {
+			i = numBuckets - 1
+		}
+		if i < 0 {
+			i = 0
+		}
+		buckets[i].Count++
+	}
+
+	return buckets
+}
+
+func renderHistogram(buckets []histogramBucket) string {
+	maxCount := 0
+	for _, b := range buckets {
+		if b.Count > maxCount {
+			maxCount = b.Count
+		}
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("Score Distribution:\n\n")
+	for _, b := range buckets {
+		bar := 0
+		if maxCount != 0 {
+			bar = b.Count * HistogramBarWidth / maxCount
+		}
+		if b.Count != 0 && bar == 0 {
+			bar = 1
+		}
+		sb.WriteString(fmt.Sprintf("%6.1f%% - %6.1f%% | %-*s %d\n", b.Low, b.High, HistogramBarWidth, strings.Repeat("#", bar), b.Count))
+	}
+	return sb.String()
+}
+
+// DistributionBuckets is how many score brackets the grade distribution
+// printed after every run has: 0-10%, 10-20%, ... 90-100%.
+const DistributionBuckets = 10
+
+// GradeStats sums up the scores of a run.
+type GradeStats struct {
+	Buckets []histogramBucket
+	Mean    float64
+	Median  float64
+	StdDev  float64
+}
+
+// computeDistribution buckets the submissions' scores and works out their
+// mean, median and (population) standard deviation.
+func computeDistribution(scores []float64) GradeStats {
+	stats := GradeStats{Buckets: scoreHistogram(scores, DistributionBuckets)}
+	if len(scores) == 0 {
+		return stats
+	}
+
+	scores = append([]float64{}, scores...)
+	sum := 0.0
+	for _, score := range scores {
+		sum += score
+	}
+	sort.Float64s(scores)
+	n := len(scores)
+	stats.Mean = sum / float64(n)
+	if n%2 == 1 {
+		stats.Median = scores[n/2]
+	} else {
+		stats.Median = (scores[n/2-1] + scores[n/2]) / 2
+	}
+
+	variance := 0.0
+	for _, score := range scores {
+		variance += (score - stats.Mean) * (score - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(variance / float64(n))
+	return stats
+}
+
+// printDistribution logs the grade distribution and the score statistics.
+// withBuckets is false when --histogram has already printed a distribution.
+func printDistribution(stats GradeStats, withBuckets bool) {
+	counts := make([]int, 0, len(stats.Buckets))
+	for _, b := range stats.Buckets {
+		counts = append(counts, b.Count)
+	}
+
+	text := fmt.Sprintf("Mean: %.2f%%  Median: %.2f%%  Std Dev: %.2f%%", stats.Mean, stats.Median, stats.StdDev)
+	if withBuckets {
+		text = renderHistogram(stats.Buckets) + "\n" + text
+	}
+	logInfo(logFields{"mean": stats.Mean, "median": stats.Median, "stdDev": stats.StdDev, "buckets": counts}, "%s", text)
+}
+
+func writeHistogram(repDir string, scores []float64, numBuckets int) error {
+	buckets := scoreHistogram(scores, numBuckets)
+	text := renderHistogram(buckets)
+	counts := make([]int, 0, len(buckets))
+	for _, b := range buckets {
+		counts = append(counts, b.Count)
+	}
+	logInfo(logFields{"buckets": counts}, "%s", strings.TrimSuffix(text, "\n"))
+
+	err := os.WriteFile(filepath.Join(repDir, "histogram.txt"), []byte(text), 0666)
+	if err != nil {
+		return err
+	}
+
+	// Raw bucket data for plotting elsewhere
+	f, err := os.Create(filepath.Join(repDir, "histogram.csv"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"low", "high", "count"})
+	for _, b := range buckets {
+		w.Write([]string{
+			strconv.FormatFloat(b.Low, 'f', 2, 64),
+			strconv.FormatFloat(b.High, 'f', 2, 64),
+			strconv.Itoa(b.Count),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// caseCounts splits a submission's cases into passed, timed out, and failed
+// for any other reason (including not being run).
+func caseCounts(sub *Submission) (passed, failed, timeout int) {
+	for _, res := range sub.RunResults {
+		switch {
+		case res.Passed():
+			passed++
+		case res.Status == STATUS_TIMEOUT:
+			timeout++
+		default:
+			failed++
+		}
+	}
+	return passed, failed, timeout
+}
+
+// mismatchCounts counts the cases that ran but whose output didn't match,
+// split into plain diffs and output in an invalid format.
+func mismatchCounts(sub *Submission) (diffs, formats int) {
+	for _, res := range sub.RunResults {
+		if !res.Graded() {
+			continue
+		}
+		if res.FormatError() != "" {
+			formats++
+		} else if !res.Match || res.StderrMismatch() {
+			diffs++
+		}
+	}
+	return diffs, formats
+}
+
+// summaryHeader is the first line of summary.csv. The first columns are the
+// gradebook ones; the rest break the cases down by how they ran.
+var summaryHeader = []string{"StudentName", "Compiled", "Passed", "Failed", "Timeout", "Score",
+	"Submission", "CompileStatus", "OK", "Errors", "Diffs", "Points", "MaxPoints"}
+
+// summaryRow is a submission's line in summary.csv and index.html. Rows of
+// submissions that weren't graded again are read back from the last
+// summary.csv, so they keep their place in both.
+type summaryRow struct {
+	Student                 string
+	Compiled                bool
+	Passed, Failed, Timeout int
+	Score                   float64
+	Name, Compile           string
+	OK, Errors, Diffs       int
+	Points, MaxPoints       float64
+}
+
+func newSummaryRow(sub *Submission) summaryRow {
+	r := summaryRow{
+		Student:   strings.Split(sub.Name, "_")[0],
+		Compiled:  sub.Compiled(),
+		Score:     sub.Score,
+		Name:      sub.Name,
+		Compile:   "SKIPPED",
+		Points:    sub.Points,
+		MaxPoints: sub.MaxPoints,
+	}
+	r.Passed, r.Failed, r.Timeout = caseCounts(sub)
+	diffs, formats := mismatchCounts(sub)
+	r.Diffs = diffs + formats
+	if sub.CompileResult != nil {
+		r.Compile = sub.CompileResult.Status.String()
+	}
+	for _, res := range sub.RunResults {
+		if res.Status == STATUS_OK {
+			r.OK++
+		} else if res.Crashed() {
+			r.Errors++
+		}
+	}
+	return r
+}
+
+func (r summaryRow) record() []string {
+	return []string{
+		r.Student,
+		strconv.FormatBool(r.Compiled),
+		strconv.Itoa(r.Passed),
+		strconv.Itoa(r.Failed),
+		strconv.Itoa(r.Timeout),
+		strconv.FormatFloat(r.Score, 'f', 2, 64),
+		r.Name,
+		r.Compile,
+		strconv.Itoa(r.OK),
+		strconv.Itoa(r.Errors),
+		strconv.Itoa(r.Diffs),
+		strconv.FormatFloat(r.Points, 'g', -1, 64),
+		strconv.FormatFloat(r.MaxPoints, 'g', -1, 64),
+	}
+}
+
+// parseSummaryRow reads a summary.csv line back, with cols giving each
+// column's position.
+func parseSummaryRow(record []string, cols map[string]int) (summaryRow, error) {
+	var r summaryRow
+	var err error
+	field := func(name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(record) {
+			if err == nil {
+				err = fmt.Errorf("no %s column", name)
+			}
+			return ""
+		}
+		return record[i]
+	}
+	number := func(name string) int {
+		n, perr := strconv.Atoi(field(name))
+		if perr != nil && err == nil {
+			err = fmt.Errorf("bad %s: %w", name, perr)
+		}
+		return n
+	}
+	float := func(name string) float64 {
+		f, perr := strconv.ParseFloat(field(name), 64)
+		if perr != nil && err == nil {
+			err = fmt.Errorf("bad %s: %w", name, perr)
+		}
+		return f
+	}
+
+	r.Student = field("StudentName")
+	r.Compiled = field("Compiled") == "true"
+	r.Passed = number("Passed")
+	r.Failed = number("Failed")
+	r.Timeout = number("Timeout")
+	r.Score = float("Score")
+	r.Name = field("Submission")
+	r.Compile = field("CompileStatus")
+	r.OK = number("OK")
+	r.Errors = number("Errors")
+	r.Diffs = number("Diffs")
+	r.Points = float("Points")
+	r.MaxPoints = float("MaxPoints")
+	return r, err
+}
+
+// readSummaryCSV reads the rows of an earlier run's summary.csv, by
+// submission name. A missing file has no rows.
+func readSummaryCSV(repDir string) (map[string]summaryRow, error) {
+	rows := make(map[string]summaryRow)
+	f, err := os.Open(filepath.Join(repDir, "summary.csv"))
+	if os.IsNotExist(err) {
+		return rows, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil || len(records) == 0 {
+		return rows, err
+	}
+	cols := make(map[string]int)
+	for i, name := range records[0] {
+		cols[name] = i
+	}
+	for i, record := range records[1:] {
+		r, err := parseSummaryRow(record, cols)
+		if err != nil {
+			return nil, fmt.Errorf("summary.csv line %d: %w", i+2, err)
+		}
+		rows[r.Name] = r
+	}
+	return rows, nil
+}
+
+// summaryRows are the rows for the submissions graded in this run, plus the
+// previous rows of those that weren't but still have a report in repDir,
+// in name order.
+func summaryRows(repDir string, subs []*Submission, previous map[string]summaryRow, cfg *Config) []summaryRow {
+	rows := make([]summaryRow, 0, len(subs)+len(previous))
+	graded := make(map[string]bool, len(subs))
+	for _, sub := range subs {
+		rows = append(rows, newSummaryRow(sub))
+		graded[sub.Name] = true
+	}
+	for name, r := range previous {
+		if graded[name] {
+			continue
+		}
+		if _, err := os.Stat(reportPath(repDir, name, cfg)); err == nil {
+			rows = append(rows, r)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+	return rows
+}
+
+func rowScores(rows []summaryRow) []float64 {
+	scores := make([]float64, 0, len(rows))
+	for _, r := range rows {
+		scores = append(scores, r.Score)
+	}
+	return scores
+}
+
+// writeSummaryCSV writes one row per submission to summary.csv, ready to
+// paste into a gradebook.
+func writeSummaryCSV(repDir string, rows []summaryRow) error {
+	f, err := os.Create(filepath.Join(repDir, "summary.csv"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write(summaryHeader)
+	for _, r := range rows {
+		w.Write(r.record())
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// printTimeoutAdvice looks at how long cases ran relative to the timeout.
+// If lots of submissions time out while others finish just under the limit,
+// the limit is probably too tight rather than everyone's code being slow.
+func printTimeoutAdvice(subs []*Submission, timeoutSec int) {
+	limit := time.Duration(timeoutSec) * time.Second
+	if limit <= 0 {
+		return
+	}
+
+	timedOutSubs := 0
+	nearSubs := 0
+	numTimedOut := 0
+	hitLimits := make(map[time.Duration]bool)
+	completed := make([]int, 4) // quarters of the timeout
+	for _, sub := range subs {
+		timedOut := false
+		near := false
+		for _, res := range sub.RunResults {
+			if res.Status == STATUS_SKIPPED {
+				continue
+			}
+
+			// Cases can have their own timeout, from <case>.timeout, timeouts.json
+			// or their .meta file, which the run recorded in res.Limit
+			caseLimit := limit
+			if res.Limit > 0 {
+				caseLimit = res.Limit
+			}
+			if res.Status == STATUS_TIMEOUT {
+				timedOut = true
+				numTimedOut++
+				hitLimits[caseLimit] = true
+				continue
+			}
+
+			frac := float64(res.Duration) / float64(caseLimit)
+			i := int(frac * float64(len(completed)))
+			if i >= len(completed) {
+				i = len(completed) - 1
+			}
+			completed[i]++
+
+			if frac >= NearTimeoutFraction {
+				near = true
+			}
+		}
+		if timedOut {
+			timedOutSubs++
+		}
+		if near {
+			nearSubs++
+		}
+	}
+
+	if timedOutSubs == 0 {
+		return
+	}
+
+	// Only name the timeout if every case that hit it had the same one
+	which := "their"
+	fields := logFields{"timedOutCases": numTimedOut, "timedOutSubmissions": timedOutSubs, "completedByQuarter": completed}
+	if len(hitLimits) == 1 {
+		for hit := range hitLimits {
+			which = "the " + hit.String()
+			fields["timeout"] = hit.String()
+		}
+	}
+	logInfo(fields, "%d case(s) across %d submission(s) hit %s timeout.", numTimedOut, timedOutSubs, which)
+	logInfo(nil, "Runtimes of cases that finished, as a share of their timeout:")
+	for i, n := range completed {
+		logInfo(nil, "  %3d%% - %3d%%: %d", i*100/len(completed), (i+1)*100/len(completed), n)
+	}
+	if nearSubs != 0 {
+		logWarn(logFields{"timedOutSubmissions": timedOutSubs, "nearSubmissions": nearSubs},
+			"%d submission(s) timed out; %d completed a case just under the limit (>= %d%% of it) - consider raising the timeout.",
+			timedOutSubs, nearSubs, int(NearTimeoutFraction*100))
+	}
+}
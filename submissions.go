@@ -1,17 +1,23 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/sergi/go-diff/diffmatchpatch"
@@ -20,343 +26,1652 @@ import (
 
 const VerboseNumLines = 50
 
+// KillGracePeriod is how long to wait for a killed program's output to be
+// collected before giving up on it.
+const KillGracePeriod = time.Second
+
+// Defaults used when --path / --timeout / --max-output-bytes aren't given
+const (
+	DefaultTargetDir = "."
+	DefaultTimeout   = 5
+
+	DefaultMaxOutputBytes = 10 << 20
+)
+
 func main() {
+	// A panic here still takes the scratch folders with it
+	defer scratch.sweepOnPanic()
+
 	app := &cli.App{
 		Name: "SubmissionChecker",
-		Usage: "./submissioncheck -p <target directory> -t <timeout in seconds>\n\n" +
+		Usage: "./submissioncheck [-p <target directory>] [-t <timeout in seconds>]\n\n" +
 			"Your target directory MUST contain the following folders:\n\n" +
 			"submissions - all student submissions, unaltered from the canvas download form.\n\n" +
-			"testcases - all testcase files, organized so that all inputs are in alphabetic order and all outputs are in alphabetic order.\nAll inputs MUST end in <.in> and all outputs MUST end in <.out>.\n\n(for context, this program filters into two groups by the <.xxx> extension, and then sorts each group alphabetically and assumes each ith <.in> file correlates with the ith <.out> file)",
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:     "path",
-				Aliases:  []string{"p"},
-				Usage:    "path to project folder that contains submissions / testcases",
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:     "timeout",
-				Aliases:  []string{"t"},
-				Usage:    "timeout threshold when running tests, in seconds",
-				Required: true,
+			"testcases - all testcase files. All inputs MUST end in <.in> and all outputs MUST end in <.out>.\n\n(for context, each <name>.in is paired with the <name>.out of the same name, and cases run in natural order, so 2 comes before 10)",
+		Flags: appFlags(),
+		Action: func(c *cli.Context) error {
+			cfg, err := configFromContext(c)
+			if err != nil {
+				return err
+			}
+			return run(cfg)
+		},
+		Commands: []*cli.Command{
+			{
+				Name:      "diff",
+				Usage:     "compare an expected output file against an actual output file without compiling or running anything",
+				ArgsUsage: "<expected> <actual>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 2 {
+						cli.ShowCommandHelp(c, "diff")
+						return fmt.Errorf("diff needs exactly two files, got %d", c.NArg())
+					}
+
+					cfg, err := configFromContext(c)
+					if err != nil {
+						return err
+					}
+					return runDiff(c.Args().Get(0), c.Args().Get(1), cfg)
+				},
 			},
-			&cli.BoolFlag{
-				Name:     "verbose",
-				Aliases:  []string{"v"},
-				Usage:    "print full out/diff logs, even if the output is very large",
-				Required: false,
-				Value:    false,
+			{
+				Name:  "clean",
+				Usage: "remove the scratch folders and half-stored --cache-dir entries left behind by runs that were killed or crashed",
+				Action: func(c *cli.Context) error {
+					cfg, err := configFromContext(c)
+					if err != nil {
+						return err
+					}
+					return cleanLeftovers(cfg)
+				},
 			},
 		},
-		Action: func(c *cli.Context) error {
-			run(c.String("path"), c.String("timeout"), c.Bool("verbose"))
+	}
+
+	err := app.Run(os.Args)
+	scratch.sweep()
+	if err != nil {
+		logError(nil, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg *Config) error {
+	// Target folder contains Submissions folder (with raw submissions)
+	// and testcases folder (with matching <whatever>.in / .out pairs)
+	subDir := filepath.Join(cfg.TargetDir, cfg.SubmissionsDir)
+	testsDir := filepath.Join(cfg.TargetDir, cfg.TestsDir)
+	for _, dir := range []string{cfg.TargetDir, subDir, testsDir} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("can't use %s: %w", dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("can't use %s: not a directory", dir)
+		}
+	}
+
+	cases, err := getTestCases(testsDir)
+	if err != nil {
+		return err
+	}
+	if cfg.DryRun {
+		return dryRun(subDir, testsDir, cases, cfg)
+	}
+
+	if cfg.MetricsAddr != "" {
+		srv, err := serveMetrics(cfg.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+	}
+	if cfg.DB != "" {
+		err = history.open(cfg.DB, assignmentName(cfg.TargetDir))
+		if err != nil {
+			return err
+		}
+		defer history.close()
+	}
+
+	// Ctrl-C stops the run the same way its deadline does, which covers
+	// everything from here on, including expanding test families. A second
+	// Ctrl-C exits straight away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if cfg.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.deadline)
+		defer cancel()
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logInfo(logFields{"seed": seed}, "Using seed %d (pass --seed %d to reproduce this run)", seed, seed)
+
+	// Test folders go in a scratch folder of their own, so runs never clash
+	// with each other or leave anything behind in the working directory
+	workDir, err := makeScratchDir()
+	if err != nil {
+		return err
+	}
+	defer scratch.remove(workDir)
+	if cfg.Sandbox == SandboxUser {
+		// The sandbox user has to get into the test folders, but needn't
+		// list them
+		err = os.Chmod(workDir, 0711)
+		if err != nil {
+			return err
+		}
+	}
+	namer := newDirNamer(seed, workDir)
+
+	genCases, err := expandFamilies(ctx, testsDir, filepath.Join(cfg.TargetDir, "generated-testcases"), cfg, namer)
+	if err != nil {
+		return err
+	}
+	cases = append(cases, genCases...)
+	timeouts, err := readCaseTimeouts(testsDir, cases)
+	if err != nil {
+		return err
+	}
+	err = readCasePoints(testsDir, cases)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Serve != "" {
+		uploadDir := filepath.Join(workDir, "uploads")
+		err = os.Mkdir(uploadDir, 0777)
+		if err != nil {
+			return err
+		}
+		return serveSubmissions(ctx, cfg.Serve, uploadDir, cases, timeouts, cfg, namer)
+	}
+
+	// Reports from an earlier run are kept when grading incrementally, and
+	// submissions that haven't changed since are left out of this one
+	repDir := filepath.Join(cfg.TargetDir, "reports")
+	incremental := cfg.Incremental && !cfg.Force
+	if !incremental && !cfg.filtersSubmissions() {
+		os.RemoveAll(repDir)
+	}
+	os.Mkdir(repDir, 0777)
+	testsChanged := newestModTime(testsDir)
+	upToDate := 0
+
+	// Submissions that aren't graded again, for being up to date or left out
+	// by --only/--skip, keep their rows in the summary files
+	var previous map[string]summaryRow
+	if incremental || cfg.filtersSubmissions() {
+		previous, err = readSummaryCSV(repDir)
+		if err != nil {
+			logWarn(logFields{"error": err}, "Could not read the last summary.csv, so it will only list the submissions graded now: %v", err)
+		}
+	}
+
+	// Run Submissions
+	jobs, skipped, err := findSubmissions(subDir, cfg)
+	if err != nil {
+		return err
+	}
+	var seen map[string]time.Time
+	if cfg.Watch {
+		seen = modTimes(jobs)
+	}
+	duplicateOf := findDuplicates(jobs, cfg.Naming)
+	if incremental {
+		stale := make([]string, 0, len(jobs))
+		for _, path := range jobs {
+			if !reportIsCurrent(repDir, path, testsChanged, cfg) {
+				stale = append(stale, path)
+			} else if _, ok := previous[submissionName(path)]; !ok && previous != nil {
+				logWarn(logFields{"submission": path}, "%s is up to date but missing from the last summary.csv, so the summary files leave it out (pass --force to regrade it)", path)
+			}
+		}
+		upToDate = len(jobs) - len(stale)
+		jobs = stale
+	}
+	if upToDate != 0 {
+		logInfo(logFields{"upToDate": upToDate}, "Skipping %d submission(s) whose reports are up to date (pass --force to regrade them).", upToDate)
+	}
+
+	submissions, failures := runSubmissions(ctx, jobs, cases, timeouts, cfg, namer)
+	for _, sub := range submissions {
+		sub.DuplicateOf = duplicateOf[sub.Name]
+	}
+
+	sort.Slice(submissions, func(i, j int) bool {
+		return submissions[i].Name < submissions[j].Name
+	})
+
+	// Grading and rendering run in parallel, but the size budget is handed
+	// out in name order so it always cuts off the same reports.
+	reports := make([]*bytes.Buffer, len(submissions))
+	err = inParallel(len(submissions), cfg.ReportWorkers, func(i int) error {
+		sub := submissions[i]
+		err := gradeSubmission(sub, cases, cfg)
+		if err != nil {
+			return err
+		}
+		applyLatePenalty(sub, cfg)
+		logVerdicts(sub, cases)
+		metrics.observe(sub)
+
+		if cfg.writesText() {
+			reports[i] = &bytes.Buffer{}
+			renderReport(reports[i], cases, sub, cfg)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	budget := &reportBudget{limit: cfg.MaxReportBytes}
+	if cfg.writesText() {
+		for i, sub := range submissions {
+			if !budget.take(int64(reports[i].Len())) {
+				reports[i].Reset()
+				renderSummaryReport(reports[i], cases, sub, budget.limit)
+			}
+		}
+	}
+
+	// A report that can't be written shouldn't cost everyone else theirs
+	writeErrs := make([]error, len(submissions))
+	inParallel(len(submissions), cfg.ReportWorkers, func(i int) error {
+		writeErrs[i] = writeReports(repDir, cases, submissions[i], reports[i], cfg)
+		return nil
+	})
+	unwritten := 0
+	for i, err := range writeErrs {
+		if err != nil {
+			unwritten++
+			logError(logFields{"submission": submissions[i].Name, "error": err}, "could not write report for %s: %v", submissions[i].Name, err)
+		}
+	}
+	rows := summaryRows(repDir, submissions, previous, cfg)
+	if cfg.writesHTML() {
+		err = writeHTMLIndex(repDir, rows)
+		if err != nil {
+			return err
+		}
+	}
+	if budget.exhausted {
+		logWarn(logFields{"maxReportBytes": budget.limit}, "Report budget of %d bytes was used up; later reports were written in summary form.", budget.limit)
+	}
+
+	err = writeSummaryCSV(repDir, rows)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Histogram {
+		err = writeHistogram(repDir, rowScores(rows), cfg.HistogramBuckets)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = history.record(cases, submissions)
+	if err != nil {
+		return fmt.Errorf("could not save results to %s: %w", cfg.DB, err)
+	}
+
+	printTimeoutAdvice(submissions, cfg.Timeout)
+	if len(rows) != 0 {
+		printDistribution(computeDistribution(rowScores(rows)), !cfg.Histogram)
+	}
+
+	if len(skipped) != 0 {
+		err = writeSkipped(repDir, skipped)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(failures) != 0 {
+		logError(logFields{"failures": len(failures)}, "%d submission(s) could not be graded and scored 0:", len(failures))
+		for _, err := range failures {
+			logError(logFields{"error": err}, "  %v", err)
+		}
+	}
+
+	if cfg.Watch {
+		logInfo(nil, "All Reports Completed.")
+		return watchSubmissions(ctx, subDir, repDir, seen, cases, timeouts, cfg, namer)
+	}
+
+	if unwritten != 0 {
+		return fmt.Errorf("%d of %d report(s) could not be written", unwritten, len(submissions))
+	}
+	incomplete := 0
+	for _, sub := range submissions {
+		if sub.Incomplete {
+			incomplete++
+		}
+	}
+	if incomplete != 0 {
+		stopped := "the run was interrupted"
+		if ctx.Err() == context.DeadlineExceeded {
+			stopped = fmt.Sprintf("the --deadline of %s passed", cfg.deadline)
+		}
+		return fmt.Errorf("%s before %d of %d submission(s) were fully graded; their reports list the cases that didn't run as SKIPPED", stopped, incomplete, len(submissions))
+	}
+	logInfo(logFields{"submissions": len(submissions)}, "All Reports Completed. Exiting...")
+	logInfo(nil, "Please make sure to check error logs as students may have incongruent filenames to class names!!")
+	return nil
+}
+
+// findSubmissions lists the submissions in subDir that --only and --skip let
+// through, along with anything that was left out for being nested too deep.
+func findSubmissions(subDir string, cfg *Config) (jobs, skipped []string, err error) {
+	jobs = make([]string, 0)
+	skipped = make([]string, 0)
+	err = filepath.Walk(subDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Anything nested deeper than expected is almost certainly not a raw
+		// submission, and its name won't follow the canvas naming scheme.
+		depth := pathDepth(subDir, path)
+		if info.IsDir() {
+			// A folder of .java files is one submission split over classes
+			if depth > 0 && (cfg.MaxDepth == 0 || depth <= cfg.MaxDepth) && isJavaDir(path) {
+				if cfg.wantsSubmission(path) {
+					jobs = append(jobs, path)
+				}
+				return filepath.SkipDir
+			}
+			if depth > 0 && cfg.MaxDepth > 0 && depth >= cfg.MaxDepth {
+				skipped = append(skipped, fmt.Sprintf("%s: folder is nested deeper than --max-depth %d", path, cfg.MaxDepth))
+				return filepath.SkipDir
+			}
 			return nil
-		},
+		}
+		if cfg.MaxDepth > 0 && depth > cfg.MaxDepth {
+			skipped = append(skipped, fmt.Sprintf("%s: file is nested deeper than --max-depth %d", path, cfg.MaxDepth))
+			return nil
+		}
+		if filepath.Ext(path) == MetaExt || !cfg.wantsSubmission(path) {
+			return nil
+		}
+
+		jobs = append(jobs, path)
+		return nil
+	})
+	return jobs, skipped, err
+}
+
+// filtersSubmissions reports whether --only or --skip leave out some
+// submissions, whose reports are then kept.
+func (cfg *Config) filtersSubmissions() bool {
+	return len(cfg.Only) != 0 || len(cfg.Skip) != 0
+}
+
+// wantsSubmission reports whether the submission at path gets graded: its
+// name matches --only, if given, and not --skip. Under canvas naming the
+// student's part of the name, before the first _, counts as well.
+func (cfg *Config) wantsSubmission(path string) bool {
+	names := []string{submissionName(path)}
+	if cfg.Naming == NamingCanvas {
+		if student := strings.SplitN(names[0], "_", 2)[0]; student != names[0] {
+			names = append(names, student)
+		}
+	}
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			for _, name := range names {
+				if ok, _ := filepath.Match(pattern, name); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if len(cfg.Only) != 0 && !matches(cfg.Only) {
+		logDebug(logFields{"submission": path}, "not grading %s: it doesn't match --only", path)
+		return false
+	}
+	if matches(cfg.Skip) {
+		logDebug(logFields{"submission": path}, "not grading %s: it matches --skip", path)
+		return false
+	}
+	return true
+}
+
+// logVerdicts prints whether each of a graded submission's cases passed.
+func logVerdicts(sub *Submission, cases []TestCase) {
+	for i, res := range sub.RunResults {
+		verdict := colorize("FAIL", ColorRed)
+		if res.Passed() {
+			verdict = colorize("PASS", ColorGreen)
+		} else if res.Status == STATUS_TIMEOUT {
+			verdict = colorize("TIMEOUT", ColorYellow)
+		}
+		logProgress(logFields{"submission": sub.Name, "case": cases[i].Out, "status": res.Status.String(), "passed": res.Passed()},
+			"%s case %s: %s", sub.Name, cases[i].Out, verdict)
+	}
+}
+
+// writeReports writes a graded submission's report in every format asked
+// for. report is its rendered text report, if text reports are on.
+func writeReports(repDir string, cases []TestCase, sub *Submission, report *bytes.Buffer, cfg *Config) error {
+	logProgress(logFields{"submission": sub.Name}, "Writing report for %s...", sub.Name)
+	if cfg.writesText() {
+		err := writeFileAtomic(filepath.Join(repDir, sub.Name+".txt"), report.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.writesJSON() {
+		err := writeJSONReport(repDir, cases, sub)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.writesHTML() {
+		err := writeHTMLReport(repDir, cases, sub)
+		if err != nil {
+			return err
+		}
+	}
+	if sub.Incomplete {
+		return backdateReports(repDir, sub)
+	}
+	return nil
+}
+
+// backdateReports makes a submission's reports look older than anything
+// they were graded from, so --incremental grades it again next time.
+func backdateReports(repDir string, sub *Submission) error {
+	epoch := time.Unix(0, 0)
+	for _, ext := range []string{".txt", ".json", ".html"} {
+		err := os.Chtimes(filepath.Join(repDir, sub.Name+ext), epoch, epoch)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data in one step, so anyone
+// reading reports while they are regenerated never sees one half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0666)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// runSubmissions compiles and runs the submissions at paths on up to
+// cfg.Workers at once. Results come back in the same order as paths. One that
+// can't be run still gets a failed Submission, and its error is returned
+// among the failures rather than stopping the others.
+func runSubmissions(ctx context.Context, paths []string, cases []TestCase, timeouts map[string]int, cfg *Config, namer *dirNamer) (submissions []*Submission, failures []error) {
+	// Names are handed out up front so a seeded run gets the same folders no
+	// matter which worker picks up which submission.
+	dirs := make([]string, len(paths))
+	for i, path := range paths {
+		dirs[i] = namer.name(path)
+	}
+
+	submissions = make([]*Submission, len(paths))
+	errs := make([]error, len(paths))
+	progress := &progressCounter{total: len(paths)}
+	inParallel(len(paths), cfg.Workers, func(i int) error {
+		if ctx.Err() != nil {
+			submissions[i] = unstartedSubmission(paths[i], cases, stoppedReason(ctx))
+			return nil
+		}
+		progress.start(submissionName(paths[i]))
+		sub, err := runSubmission(ctx, paths[i], dirs[i], cases, timeouts, cfg)
+		if err == nil {
+			sub.SubmittedAt, err = readSubmittedAt(paths[i])
+		}
+		if err != nil {
+			logError(logFields{"path": paths[i], "error": err}, "could not grade %s: %v", paths[i], err)
+			sub = failedSubmission(paths[i], cases, err)
+			errs[i] = fmt.Errorf("%s: %w", paths[i], err)
+		}
+		submissions[i] = sub
+		return nil
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			failures = append(failures, err)
+		}
+	}
+	return submissions, failures
+}
+
+// failedSubmission stands in for a submission that couldn't be run, so it
+// still gets a report and a row in the summary, with every case skipped.
+func failedSubmission(path string, cases []TestCase, err error) *Submission {
+	sub := &Submission{
+		Name:       submissionName(path),
+		RunResults: make([]*Result, 0, len(cases)),
+		Failure:    err.Error(),
+	}
+	for range cases {
+		sub.RunResults = append(sub.RunResults, &Result{
+			Status: STATUS_SKIPPED,
+			reason: "submission could not be run",
+		})
+	}
+	return sub
+}
+
+// unstartedSubmission stands in for a submission the run was stopped before
+// reaching.
+func unstartedSubmission(path string, cases []TestCase, reason string) *Submission {
+	sub := &Submission{
+		Name:       submissionName(path),
+		RunResults: make([]*Result, 0, len(cases)),
+		Incomplete: true,
+	}
+	for range cases {
+		sub.RunResults = append(sub.RunResults, &Result{
+			Status: STATUS_SKIPPED,
+			reason: reason,
+		})
+	}
+	return sub
+}
+
+// inParallel calls fn(0) to fn(n-1) on up to workers goroutines at once and
+// returns the error from the lowest i that failed, if any.
+func inParallel(n, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer scratch.sweepOnPanic()
+			for i := range next {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportIsCurrent reports whether the submission at path already has a
+// report written after both it and the test cases last changed.
+func reportIsCurrent(repDir, path string, testsChanged time.Time, cfg *Config) bool {
+	report, err := os.Stat(reportPath(repDir, submissionName(path), cfg))
+	if err != nil {
+		return false
+	}
+	changed := newestModTime(path)
+	if testsChanged.After(changed) {
+		changed = testsChanged
+	}
+	return report.ModTime().After(changed)
+}
+
+// reportPath is where the named submission's main report goes: the text
+// one, or the JSON or HTML one when there is no text report.
+func reportPath(repDir, name string, cfg *Config) string {
+	ext := ".txt"
+	if !cfg.writesText() && cfg.writesJSON() {
+		ext = ".json"
+	} else if !cfg.writesText() {
+		ext = ".html"
+	}
+	return filepath.Join(repDir, name+ext)
+}
+
+// newestModTime is the latest modification time of path or, for a folder,
+// anything inside it.
+func newestModTime(path string) time.Time {
+	var newest time.Time
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest
+}
+
+// pathDepth returns how many path elements path is below root, so a file
+// directly inside root has depth 1.
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return len(strings.Split(rel, string(filepath.Separator)))
+}
+
+func writeSkipped(repDir string, skipped []string) error {
+	logWarn(logFields{"skipped": len(skipped)}, "Skipped %d path(s) in the submissions folder that were not where submissions are expected:", len(skipped))
+	for _, s := range skipped {
+		logWarn(logFields{"reason": s}, "  %s", s)
+	}
+
+	f, err := os.Create(filepath.Join(repDir, "skipped.txt"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	f.WriteString("The following paths were not graded because they were not where submissions are expected.\n")
+	f.WriteString("Move them directly into the submissions folder or raise --max-depth to grade them.\n\n")
+	for _, s := range skipped {
+		f.WriteString(s + "\n")
+	}
+	return nil
+}
+
+// runSubmission builds the submission at path in dir and runs it on every
+// case. Cases listed in timeouts get that many seconds instead of cfg.Timeout.
+func runSubmission(ctx context.Context, path, dir string, cases []TestCase, timeouts map[string]int, cfg *Config) (*Submission, error) {
+	lang := cfg.languageFor(path)
+	if java, ok := lang.(*JavaLanguage); ok {
+		java, err := java.forSubmission(path)
+		if err != nil {
+			return nil, err
+		}
+		lang = java
+	}
+	file, err := lang.Setup(path, dir)
+	if err != nil {
+		// A folder that was already there belongs to someone else
+		if !os.IsExist(err) {
+			os.RemoveAll(dir)
+		}
+		return nil, err
+	}
+
+	// The folder only holds what was submitted until it is compiled
+	var sources []SourceFile
+	if cfg.IncludeSource {
+		sources, err = readSources(dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+
+	// Grade a single method through a generated driver that calls it
+	mainFile := file
+	if _, ok := lang.(*JavaLanguage); ok && cfg.Driver != "" {
+		driverClass, err := writeDriver(cfg.Driver, dir, strings.TrimSuffix(file, ".java"))
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		mainFile = driverClass + ".java"
+	}
+
+	sub := &Submission{
+		Name:       submissionName(path),
+		RunResults: make([]*Result, 0),
+		Sources:    sources,
+	}
+
+	// Compile
+	sub.CompileResult = compileWithCache(ctx, lang, dir, file, cfg)
+	if sub.compileFailed() && ctx.Err() != nil {
+		// Killed part way through, so it says nothing about the submission
+		os.RemoveAll(dir)
+		return unstartedSubmission(path, cases, stoppedReason(ctx)), nil
+	}
+	if sub.compileFailed() {
+		for range cases {
+			sub.RunResults = append(sub.RunResults, &Result{
+				Status: STATUS_SKIPPED,
+				reason: "submission did not compile",
+			})
+		}
+		os.RemoveAll(dir)
+		return sub, nil
+	}
+
+	// Everything in the folder now is expected to be there
+	var artifacts map[string]bool
+	if cfg.VerifyClean {
+		var err error
+		artifacts, err = listFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Run test cases
+	for _, tc := range cases {
+		if ctx.Err() != nil {
+			sub.RunResults = append(sub.RunResults, &Result{Status: STATUS_SKIPPED, reason: stoppedReason(ctx)})
+			sub.Incomplete = true
+			continue
+		}
+		inFile := tc.In
+		logDebug(logFields{"submission": sub.Name, "case": inFile}, "case %s...", inFile)
+		caseFiles, err := copyCaseFiles(inFile, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		limits := cfg.limits()
+		timeout, ownLimit := timeouts[inFile]
+		if ownLimit {
+			limits.Timeout = timeout
+		}
+
+		res, err := lang.Run(ctx, dir, mainFile, inFile, tc.Args, limits)
+		if err != nil {
+			return nil, err
+		}
+
+		// An empty, successful run can be a transient flake on a busy
+		// machine, so give it one more chance before grading it.
+		if cfg.RetryEmpty && res.Status == STATUS_OK && res.out == "" {
+			logProgress(logFields{"submission": sub.Name, "case": inFile}, "case %s produced no output, re-running...", inFile)
+			res, err = lang.Run(ctx, dir, mainFile, inFile, tc.Args, limits)
+			if err != nil {
+				return nil, err
+			}
+			res.Retried = true
+		}
+
+		// Timeouts and failures to even run can come from a loaded machine
+		// rather than the program, so give those --retries more chances
+		failed := res
+		for attempt := 1; attempt <= cfg.Retries && res.transientFailure() && ctx.Err() == nil; attempt++ {
+			logProgress(logFields{"submission": sub.Name, "case": inFile, "status": res.Status.String(), "attempt": attempt + 1},
+				"case %s: %s, re-running (retry %d of %d)...", inFile, res.Status, attempt, cfg.Retries)
+			retried := res.Retried
+			res, err = lang.Run(ctx, dir, mainFile, inFile, tc.Args, limits)
+			if err != nil {
+				return nil, err
+			}
+			res.Retried = retried
+			res.Attempts = attempt + 1
+			res.firstFailure = failed.describeStatus()
+		}
+		res.ownLimit = ownLimit
+		res.Args = tc.Args
+		if res.Status == STATUS_SKIPPED {
+			sub.Incomplete = true
+		}
+		removeCaseFiles(dir, caseFiles)
+
+		sub.RunResults = append(sub.RunResults, res)
+	}
+
+	if cfg.VerifyClean {
+		var err error
+		sub.Stray, err = strayFiles(dir, artifacts)
+		if err != nil {
+			return nil, err
+		}
+		if len(sub.Stray) != 0 {
+			logWarn(logFields{"submission": sub.Name, "files": sub.Stray}, "%s left unexpected files behind: %s", sub.Name, strings.Join(sub.Stray, ", "))
+		}
+	}
+
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// listFiles returns the paths (relative to dir) of everything inside dir.
+func listFiles(dir string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = true
+		return nil
+	})
+	return files, err
+}
+
+// strayFiles lists anything in dir that wasn't among the expected artifacts,
+// i.e. files the submission created while it was being tested.
+func strayFiles(dir string, artifacts map[string]bool) ([]string, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	stray := make([]string, 0)
+	for f := range files {
+		if !artifacts[f] {
+			stray = append(stray, f)
+		}
+	}
+	sort.Strings(stray)
+	return stray, nil
+}
+
+// isDirectExec reports whether a submission is a script or prebuilt binary
+// that should be run directly rather than compiled: either it has the
+// executable bit set or it starts with a #! line.
+func isDirectExec(path string) bool {
+	if filepath.Ext(path) == ".java" {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if info.Mode()&0111 != 0 {
+		return true
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	shebang := make([]byte, 2)
+	_, err = io.ReadFull(f, shebang)
+	return err == nil && string(shebang) == "#!"
+}
+
+// RunLimits caps what a single run of a program may use.
+type RunLimits struct {
+	Timeout        int   // seconds
+	MaxOutputBytes int64 // per stream, 0 = unlimited
+	MaxMemoryBytes int64 // address space, 0 = unlimited
+	MaxPeakKB      int64 // peak resident memory, checked once the run ends; 0 = unchecked
+
+	executor Executor // how the program is started; nil runs it directly
+	// containerMemory is the memory limit as given, which a container gets
+	// in full even when a language has turned MaxMemoryBytes into something
+	// else, like Java's heap size
+	containerMemory int64
+}
+
+func (cfg *Config) limits() RunLimits {
+	return RunLimits{Timeout: cfg.Timeout, MaxOutputBytes: cfg.MaxOutputBytes, MaxMemoryBytes: cfg.maxMemory, MaxPeakKB: int64(cfg.MaxMemoryMB) * 1024, executor: cfg.executor, containerMemory: cfg.maxMemory}
+}
+
+// stoppedReason is why a case was skipped once ctx, the run's context, was
+// cancelled.
+func stoppedReason(ctx context.Context) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return "the run's --deadline passed before it finished"
+	}
+	return "the run was interrupted before it finished"
+}
+
+// outOfMemoryMarkers are what Java, C++ and Python print when an allocation
+// fails, so a crash from hitting the memory limit can be told apart.
+var outOfMemoryMarkers = []string{"java.lang.OutOfMemoryError", "std::bad_alloc", "MemoryError"}
+
+func outOfMemory(stderr string) bool {
+	for _, marker := range outOfMemoryMarkers {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// withMemoryLimit wraps command so it runs with its address space capped,
+// using the shell's ulimit since os/exec can't set rlimits itself.
+func withMemoryLimit(command []string, maxBytes int64) []string {
+	if maxBytes <= 0 {
+		return command
+	}
+	kb := strconv.FormatInt((maxBytes+1023)/1024, 10)
+	return append([]string{"/bin/sh", "-c", `ulimit -v "$1" && shift && exec "$@"`, "sh", kb}, command...)
+}
+
+// cappedBuffer keeps at most limit bytes of what is written to it. Past that
+// it stops accepting writes and closes full, so the program can be stopped.
+// The buffer is not embedded, so io.Copy can't bypass Write via ReadFrom.
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	limit int64
+	full  chan struct{}
+	once  *sync.Once
+}
+
+var errOutputLimit = errors.New("output limit exceeded")
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	room := b.limit - int64(b.buf.Len())
+	if b.limit > 0 && int64(len(p)) > room {
+		n := 0
+		if room > 0 {
+			n, _ = b.buf.Write(p[:room])
+		}
+		b.once.Do(func() { close(b.full) })
+		return n, errOutputLimit
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
+
+// runExec runs command inside dir with the test input on stdin, so anything
+// the program writes to a relative path stays inside its test folder. The
+// program is killed if ctx is cancelled.
+func runExec(ctx context.Context, dir string, command []string, in string, limits RunLimits) (*Result, error) {
+	// Prepare run command
+	inFile, inSize, closeIn, err := openStdin(in)
+	if err != nil {
+		logError(logFields{"case": in}, "%v", err)
+		return nil, err
+	}
+	defer closeIn()
+
+	executor := limits.executor
+	if executor == nil {
+		executor = localExecutor{}
+	}
+	exceeded := make(chan struct{})
+	once := &sync.Once{}
+	outBuff := &cappedBuffer{limit: limits.MaxOutputBytes, full: exceeded, once: once}
+	errBuff := &cappedBuffer{limit: limits.MaxOutputBytes, full: exceeded, once: once}
+	runCmd, kill := executor.Command(dir, command, limits)
+	runCmd.Stdin = inFile
+	runCmd.Stdout = outBuff
+	runCmd.Stderr = errBuff
+
+	// Run Command
+	done := make(chan error)
+
+	runRes := &Result{Limit: time.Duration(limits.Timeout) * time.Second}
+	logDebug(logFields{"dir": dir, "command": runCmd.Args, "stdin": in}, "running in %s: %s < %s", dir, strings.Join(runCmd.Args, " "), in)
+	start := time.Now()
+	err = runCmd.Start()
+	if err != nil {
+		// Nothing is running, so there is nothing to wait for or kill
+		runRes.Status = STATUS_ERR
+		runRes.reason = err.Error()
+		runRes.err = err.Error()
+		runRes.ExitCode = -1
+		return runRes, nil
+	}
+	go func() { done <- runCmd.Wait() }()
+
+	// Start a timer
+	timeout := time.After(runRes.Limit)
+
+	killed, waited := true, false
+	select {
+	case <-timeout:
+		runRes.Status = STATUS_TIMEOUT
+	case <-ctx.Done():
+		runRes.Status = STATUS_SKIPPED
+		runRes.reason = stoppedReason(ctx)
+	case <-exceeded:
+		runRes.Status = STATUS_OUTPUT_EXCEEDED
+	case err = <-done:
+		killed, waited = false, true
+	}
+	runRes.Duration = time.Since(start)
+	if killed {
+		kill()
+
+		// Let Wait finish copying whatever was printed before the kill, unless
+		// a leftover child process is still holding the output open.
+		select {
+		case <-done:
+			waited = true
+		case <-time.After(KillGracePeriod):
+		}
+	}
+	if waited && executor.measuresMemory() {
+		runRes.MemoryKB = peakMemoryKB(runCmd.ProcessState)
+	}
+
+	// The program shares the file offset, so it shows how much input it read
+	if executor.sharesStdin() {
+		runRes.stdinSize = inSize
+		runRes.StdinRead, _ = inFile.Seek(0, io.SeekCurrent)
+	}
+
+	// Store Result
+	runRes.out = outBuff.String()
+	runRes.err = errBuff.String()
+
+	if killed {
+		runRes.ExitCode = -1
+		runRes.Signal = syscall.SIGKILL
+	} else {
+		runRes.recordExit(err)
+		runRes.Status, runRes.reason = exitStatus(err, runRes.err, limits)
+	}
+
+	// Nothing stops a program at its peak memory limit, so it is only
+	// checked here, once the program has finished by itself
+	if (runRes.Status == STATUS_OK || runRes.Status == STATUS_RUNTIME_ERR) && executor.measuresMemory() && limits.MaxPeakKB > 0 && runRes.MemoryKB > limits.MaxPeakKB {
+		runRes.Status = STATUS_MEMORY_EXCEEDED
+		runRes.reason = fmt.Sprintf("peak memory %d KB is over the %d KB limit", runRes.MemoryKB, limits.MaxPeakKB)
+	}
+
+	return runRes, nil
+}
+
+// peakMemoryKB is the most memory a finished process had resident at once.
+func peakMemoryKB(state *os.ProcessState) int64 {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
 	}
+	// Linux reports kilobytes, macOS bytes
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss) / 1024
+	}
+	return int64(usage.Maxrss)
+}
 
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
+// recordExit fills in the exit code of a process that has exited, and the
+// signal that ended it if one did, from the error Wait returned.
+func (r *Result) recordExit(err error) {
+	r.ExitCode = 0
+	if err == nil {
+		return
+	}
+	r.ExitCode = -1
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return
+	}
+	r.ExitCode = exitErr.ExitCode()
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		r.Signal = ws.Signal()
 	}
 }
 
-func run(targetDir, timeout string, verbose bool) error {
-	// Target folder contains Submissions folder (with raw submissions)
-	// and testcases folder (with <whatever>.in / .out (MUST BE ORDERED BY NUMBER))
-	subDir := filepath.Join(targetDir, "submissions")
-	testsDir := filepath.Join(targetDir, "testcases")
-	timeoutSecs, err := strconv.Atoi(timeout)
-	if err != nil {
-		return err
+// exitDescription says how the process ended, for the report.
+func (r *Result) exitDescription() string {
+	switch {
+	case r.Status == STATUS_TIMEOUT || r.Status == STATUS_OUTPUT_EXCEEDED:
+		return fmt.Sprintf("killed by the grader (signal %d, %v)", int(syscall.SIGKILL), syscall.SIGKILL)
+	case r.Signal != 0:
+		return fmt.Sprintf("killed by signal %d (%v)", int(r.Signal), r.Signal)
+	case r.ExitCode == -1:
+		return "never started"
+	case strings.Contains(r.err, "Exception in thread"):
+		return fmt.Sprintf("exit code %d (uncaught exception)", r.ExitCode)
 	}
+	return fmt.Sprintf("exit code %d", r.ExitCode)
+}
 
-	in, out := getTestNames(testsDir)
+// exitStatus classifies how a program that finished on its own exited, with
+// the exit code or signal as the reason if it failed.
+func exitStatus(err error, stderr string, limits RunLimits) (Status, string) {
+	if err == nil {
+		return STATUS_OK, ""
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		// The program never got to run, e.g. the interpreter is missing
+		return STATUS_ERR, err.Error()
+	}
+	if outOfMemory(stderr) {
+		return STATUS_MEMORY, exitErr.Error()
+	}
+	// Nothing here kills programs that finish on their own, so a SIGKILL
+	// under a memory limit is the kernel's OOM killer
+	ws, ok := exitErr.Sys().(syscall.WaitStatus)
+	if ok && ws.Signaled() && ws.Signal() == syscall.SIGKILL && limits.MaxMemoryBytes > 0 {
+		return STATUS_MEMORY, exitErr.Error()
+	}
+	// The docker client exits with 128+9 when the container is SIGKILLed,
+	// and containers always run under a memory limit
+	if _, docker := limits.executor.(dockerExecutor); docker && exitErr.ExitCode() == 128+int(syscall.SIGKILL) {
+		return STATUS_MEMORY, exitErr.Error()
+	}
+	return STATUS_RUNTIME_ERR, exitErr.Error()
+}
 
-	// Run Submissions
-	submissions := make([]*Submission, 0)
-	err = filepath.Walk(subDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// environmentalError matches errors from programs that couldn't get what
+// they needed from the machine, as opposed to bugs of their own.
+var environmentalError = regexp.MustCompile(`(?i)resource temporarily unavailable|cannot allocate memory|could not reserve enough space|insufficient memory for the java runtime|unable to create (new )?native thread|too many open files`)
+
+// transientFailure reports whether a run may have failed because of the
+// machine rather than the program: it timed out, couldn't be started, was
+// killed by something other than us, or couldn't get resources.
+func (r *Result) transientFailure() bool {
+	switch r.Status {
+	case STATUS_TIMEOUT, STATUS_ERR:
+		return true
+	case STATUS_RUNTIME_ERR:
+		return r.Signal == syscall.SIGKILL || environmentalError.MatchString(r.err)
+	}
+	return false
+}
 
-		if info.IsDir() {
-			return nil
-		}
+// describeStatus is the status with the reason for it, if there is one.
+func (r *Result) describeStatus() string {
+	if r.reason != "" {
+		return fmt.Sprintf("%s (%s)", r.Status, r.reason)
+	}
+	return r.Status.String()
+}
 
-		fmt.Printf("Running %s...\n", path)
-		sub, err := runSubmission(path, in, timeoutSecs)
+// compareCase checks a case's output against each output the case accepts
+// in turn, stopping at the first that matches. If none do, the result is
+// that of the comparison against the first.
+func compareCase(res *Result, tc TestCase, cfg *Config) error {
+	outs := tc.outputs()
+	for i, path := range outs {
+		outFile, err := readTestFile(path)
 		if err != nil {
 			return err
 		}
 
-		submissions = append(submissions, sub)
-		return nil
-	})
-	if err != nil {
-		return err
+		var match bool
+		var diffs []diffmatchpatch.Diff
+		var note, formatErr string
+		// Badly formatted output isn't worth diffing
+		if err := checkFormat(string(outFile), res.out, cfg); err != nil {
+			formatErr = err.Error()
+		} else {
+			match, diffs, note = compareOutput(string(outFile), res.out, cfg.caseComparator(tc.Out), cfg)
+		}
+		if i == 0 || match {
+			res.Match, res.diffs, res.note, res.formatErr = match, diffs, note, formatErr
+		}
+		if match {
+			if len(outs) > 1 {
+				alt := fmt.Sprintf("matched %s, accepted output %d of %d", filepath.Base(path), i+1, len(outs))
+				if res.note != "" {
+					alt = res.note + "; " + alt
+				}
+				res.note = alt
+			}
+			return nil
+		}
 	}
+	return nil
+}
 
-	// Read Submissions / write reports
-	repDir := filepath.Join(targetDir, "reports")
-	os.RemoveAll(repDir)
-	os.Mkdir(repDir, 0777)
-
-	for _, sub := range submissions {
-		fmt.Printf("Writing report for %s...\n", sub.Name)
-		writeReport(repDir, out, sub, verbose)
+// gradeSubmission diffs every run against its expected output and scores the
+// submission by the points of the cases that ran OK and matched, as a
+// percentage of all the points on offer.
+func gradeSubmission(sub *Submission, cases []TestCase, cfg *Config) error {
+	sub.Score = 0
+	sub.Points = 0
+	sub.MaxPoints = 0
+	for _, tc := range cases {
+		sub.MaxPoints += tc.Points
+	}
+	if sub.compileFailed() {
+		return nil
 	}
 
-	fmt.Println("All Reports Completed. Exiting...")
-	fmt.Println("Please make sure to check error logs as students may have incongruent filenames to class names!!")
-	return nil
-}
+	passed := 0
+	points := 0.0
+	for i, res := range sub.RunResults {
+		if !res.graded() {
+			continue
+		}
 
-func getTestNames(testsDir string) (in []string, out []string) {
-	// Sort in/out files
-	in = make([]string, 0)
-	out = make([]string, 0)
-	filepath.Walk(testsDir, func(path string, info os.FileInfo, err error) error {
+		err := compareCase(res, cases[i], cfg)
 		if err != nil {
 			return err
 		}
-
-		if info.IsDir() {
-			return nil
+		if res.formatErr != "" {
+			continue
 		}
 
-		testType := strings.Split(path, ".")[1]
-		if testType == "in" {
-			in = append(in, path)
+		// Stderr only counts for cases that say what it should be
+		if cases[i].Err != "" {
+			errFile, err := readTestFile(cases[i].Err)
+			if err != nil {
+				return err
+			}
+			var errMatch bool
+			errMatch, res.errDiffs, _ = compareOutput(string(errFile), res.err, cfg.caseComparator(cases[i].Out), cfg)
+			res.errMismatch = !errMatch
+		}
 
-		} else {
-			out = append(out, path)
+		if res.passed() {
+			passed++
+			points += cases[i].Points
 		}
-		return nil
-	})
-	sort.Strings(in)
-	sort.Strings(out)
+	}
 
-	return
+	if cfg.PartialCredit || passed == len(cases) {
+		sub.Points = points
+	}
+	if sub.MaxPoints != 0 {
+		sub.Score = 100 * sub.Points / sub.MaxPoints
+	}
+	return nil
 }
 
-func runSubmission(path string, inFiles []string, timeout int) (*Submission, error) {
-	dir, className := makeTestDir(path)
+// reportBudget caps the total number of bytes written across all reports.
+// Once a full report would go over the limit, it and every report after it
+// are written in summary form instead.
+type reportBudget struct {
+	limit     int64
+	used      int64
+	exhausted bool
+}
 
-	sub := &Submission{
-		Name:       dir,
-		RunResults: make([]*Result, 0),
+func (b *reportBudget) take(n int64) bool {
+	if b.limit <= 0 {
+		return true
 	}
-
-	// Compile
-	sub.CompileResult = runCompile(dir, className)
-	if sub.CompileResult.Status == STATUS_ERR {
-		os.RemoveAll(dir)
-		return sub, nil
+	if b.exhausted || b.used+n > b.limit {
+		b.exhausted = true
+		return false
 	}
+	b.used += n
+	return true
+}
 
-	// Run test cases
-	for _, inFile := range inFiles {
-		fmt.Printf("case %s...\n", inFile)
-		res, err := runExec(dir, className, inFile, timeout)
-		if err != nil {
-			return nil, err
-		}
-
-		sub.RunResults = append(sub.RunResults, res)
+func writeCompileHeader(f *bytes.Buffer, sub *Submission) {
+	if sub.Failure != "" {
+		f.WriteString("------------------Compile Result: SKIPPED (could not be graded)------------------\n")
+		f.WriteString(fmt.Sprintf("ERROR: %s\n\n", sub.Failure))
+		return
 	}
-	err := os.RemoveAll(dir)
-	if err != nil {
-		return nil, err
+	if sub.CompileResult == nil && sub.Incomplete {
+		f.WriteString("------------------Compile Result: SKIPPED (the run was stopped first)------------------\n")
+		return
 	}
-
-	return sub, nil
+	if sub.CompileResult == nil {
+		f.WriteString("------------------Compile Result: SKIPPED (nothing to compile)------------------\n")
+		return
+	}
+	f.WriteString(fmt.Sprintf("------------------Compile Result: %s------------------\n", sub.CompileResult.Status))
+	f.WriteString(fmt.Sprintf("Compile Time: %.2fs\n", sub.CompileResult.Duration.Seconds()))
 }
 
-func runCompile(dir, className string) *Result {
-	// Prepare javac command
-	outBuff := &bytes.Buffer{}
-	errBuff := &bytes.Buffer{}
-	compCmd := exec.Command("javac", filepath.Join(dir, className+".java"))
-	compCmd.Stdout = bufio.NewWriter(outBuff)
-	compCmd.Stderr = bufio.NewWriter(errBuff)
-
-	// Run compile Command
-	err := compCmd.Run()
-
-	compRes := &Result{
-		out: outBuff.String(),
-		err: errBuff.String(),
+// writeRuntimes lists how long each case took, to spot programs that pass
+// but are far slower than they should be.
+func writeRuntimes(f *bytes.Buffer, cases []TestCase, sub *Submission) {
+	width := len("Case")
+	for _, tc := range cases {
+		if len(tc.Out) > width {
+			width = len(tc.Out)
+		}
 	}
 
-	if err != nil {
-		compRes.Status = STATUS_ERR
-	} else {
-		compRes.Status = STATUS_OK
+	f.WriteString("------------------Runtimes------------------\n")
+	f.WriteString(fmt.Sprintf("%-*s  %8s  %8s  %11s\n", width, "Case", "Time", "Limit", "Memory"))
+	for i, res := range sub.RunResults {
+		if res.Status == STATUS_SKIPPED {
+			f.WriteString(fmt.Sprintf("%-*s  %8s  %8s  %11s\n", width, cases[i].Out, "-", "-", "-"))
+			continue
+		}
+		memory := "-"
+		if res.MemoryKB > 0 {
+			memory = strconv.FormatInt(res.MemoryKB, 10) + " KB"
+		}
+		f.WriteString(fmt.Sprintf("%-*s  %7.3fs  %7gs  %11s\n", width, cases[i].Out, res.Duration.Seconds(), res.Limit.Seconds(), memory))
 	}
-
-	return compRes
+	f.WriteString("\n")
 }
 
-func runExec(dir, className, in string, timeoutSec int) (*Result, error) {
-	// Prepare run command
-	inFile, err := os.Open(in)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
+func countStatuses(sub *Submission) map[Status]int {
+	counts := make(map[Status]int)
+	for _, res := range sub.RunResults {
+		counts[res.Status]++
 	}
-	defer inFile.Close()
-
-	outBuff := &bytes.Buffer{}
-	errBuff := &bytes.Buffer{}
-	runCmd := exec.Command("java", "-classpath", dir, className)
-	runCmd.Stdin = inFile
-	runCmd.Stdout = bufio.NewWriter(outBuff)
-	runCmd.Stderr = bufio.NewWriter(errBuff)
-
-	// Run Command
-	done := make(chan error)
-
-	runCmd.Start()
-	go func() { done <- runCmd.Wait() }()
+	return counts
+}
 
-	// Start a timer
-	timeout := time.After(time.Duration(timeoutSec) * time.Second)
-	runRes := &Result{}
+func writeRunSummary(f *bytes.Buffer, sub *Submission) {
+	counts := countStatuses(sub)
+	f.WriteString(fmt.Sprintf("------------------Run Results------------------\nTimeout: %d\nRuntime Error: %d\nMemory Limit Exceeded: %d\nPeak Memory Exceeded: %d\nOutput Limit Exceeded: %d\nCould Not Run: %d\nNo Timeout/Error: %d\nSkipped: %d\n\n",
+		counts[STATUS_TIMEOUT], counts[STATUS_RUNTIME_ERR], counts[STATUS_MEMORY], counts[STATUS_MEMORY_EXCEEDED], counts[STATUS_OUTPUT_EXCEEDED], counts[STATUS_ERR], counts[STATUS_OK], counts[STATUS_SKIPPED]))
+}
 
-	select {
-	case <-timeout:
-		runCmd.Process.Kill()
-		runRes.Status = STATUS_TIMEOUT
-	case err = <-done:
-		break
+// writeScore puts the points earned and the percentage score, after any
+// late penalty, on one line at the top of the report.
+func writeScore(f *bytes.Buffer, sub *Submission) {
+	if sub.LatePenalty != 0 {
+		f.WriteString(fmt.Sprintf("Score: %g / %g (%.2f%%; raw %.2f%%, late %d day(s) -%g%%)\n\n",
+			sub.Points, sub.MaxPoints, sub.Score, sub.RawScore, sub.DaysLate, sub.LatePenalty))
+	} else {
+		f.WriteString(fmt.Sprintf("Score: %g / %g (%.2f%%)\n\n", sub.Points, sub.MaxPoints, sub.Score))
 	}
-
-	// Store Result
-	runRes.out = outBuff.String()
-	runRes.err = errBuff.String()
-
-	if runRes.Status != STATUS_TIMEOUT {
-		if err != nil {
-			runRes.Status = STATUS_ERR
-		} else {
-			runRes.Status = STATUS_OK
-		}
+	if sub.DuplicateOf != "" {
+		f.WriteString(fmt.Sprintf("WARNING: this submission is identical to %s.\n\n", sub.DuplicateOf))
 	}
-
-	return runRes, nil
 }
 
-func writeReport(repDir string, outs []string, sub *Submission, verbose bool) error {
-	numErr := 0
-	numTimeout := 0
-	numOk := 0
+// renderSummaryReport writes only pass/fail information, for use once the
+// report size budget has run out.
+func renderSummaryReport(f *bytes.Buffer, cases []TestCase, sub *Submission, limit int64) {
+	f.WriteString(fmt.Sprintf("Report For %s\n\n", strings.Split(sub.Name, "_")[0]))
+	f.WriteString(fmt.Sprintf("NOTE: the --max-report-bytes budget of %d bytes was used up, so this report only lists pass/fail results.\n\n", limit))
+	writeScore(f, sub)
+	writeCompileHeader(f, sub)
+	writeRunSummary(f, sub)
 
-	for _, res := range sub.RunResults {
-		switch res.Status {
-		case STATUS_ERR:
-			numErr++
-		case STATUS_TIMEOUT:
-			numTimeout++
-		case STATUS_OK:
-			numOk++
+	f.WriteString("Test Cases:\n")
+	for i, res := range sub.RunResults {
+		if res.Status == STATUS_SKIPPED {
+			f.WriteString(fmt.Sprintf("Case %s: %s (%s)\n", cases[i].Out, res.Status, res.Reason()))
+			continue
 		}
-	}
 
-	f, err := os.Create(filepath.Join(repDir, sub.Name+".txt"))
-	if err != nil {
-		return err
+		verdict := "FAIL"
+		if res.Passed() {
+			verdict = "PASS"
+		} else if res.FormatError() != "" {
+			verdict = "FAIL (output format invalid)"
+		}
+		if res.Retried {
+			verdict += " (re-run after empty output)"
+		}
+		if res.Attempts > 1 {
+			verdict += fmt.Sprintf(" (on attempt %d)", res.Attempts)
+		}
+		if res.IgnoredInput() {
+			verdict += " (program did not read any input)"
+		}
+		f.WriteString(fmt.Sprintf("Case %s: %s %s\n", cases[i].Out, res.Status, verdict))
 	}
-	defer f.Close()
+}
+
+func renderReport(f *bytes.Buffer, cases []TestCase, sub *Submission, cfg *Config) {
+	verbose := cfg.Verbose
 
 	// Print Compile Result
 	f.WriteString(fmt.Sprintf("Report For %s\n\n", strings.Split(sub.Name, "_")[0]))
-	f.WriteString(fmt.Sprintf("------------------Compile Result: %s------------------\n", sub.CompileResult.Status))
-	if sub.CompileResult.Status == STATUS_ERR {
+	writeScore(f, sub)
+	writeCompileHeader(f, sub)
+	if sub.CompileFailed() {
 		f.WriteString("Error Log:\n")
-		f.WriteString(sub.CompileResult.err + "\n\n")
+		f.WriteString(sub.CompileResult.Err() + "\n\n")
+	}
+	if sub.CompileResult != nil && sub.CompileResult.Status == STATUS_WARN {
+		f.WriteString("Warning Log:\n")
+		if !verbose {
+			f.WriteString(truncLines(sub.CompileResult.Err(), VerboseNumLines) + "\n\n")
+		} else {
+			f.WriteString(sub.CompileResult.Err() + "\n\n")
+		}
 	}
-	if len(sub.CompileResult.out) != 0 {
+	if sub.CompileResult != nil && len(sub.CompileResult.Out()) != 0 {
 		f.WriteString("Out Log:\n")
 		if !verbose {
-			f.WriteString(truncLines(sub.CompileResult.out, VerboseNumLines) + "\n\n")
+			f.WriteString(truncLines(sub.CompileResult.Out(), VerboseNumLines) + "\n\n")
 		} else {
-			f.WriteString(sub.CompileResult.out + "\n\n")
+			f.WriteString(sub.CompileResult.Out() + "\n\n")
 		}
 	}
-	if sub.CompileResult.Status == STATUS_ERR {
-		return nil
+
+	if len(sub.Stray) != 0 {
+		f.WriteString("WARNING: the program left unexpected files in its working directory:\n")
+		for _, stray := range sub.Stray {
+			f.WriteString("  " + stray + "\n")
+		}
+		f.WriteString("\n")
 	}
 
 	// Print Run Results
-	f.WriteString(fmt.Sprintf("------------------Run Results------------------\nTimeout: %d\nError: %d\nNo Timeout/Error: %d\n\n",
-		numTimeout, numErr, numOk))
+	writeRunSummary(f, sub)
+	writeRuntimes(f, cases, sub)
 
-	f.WriteString("Test Cases:\n")
-	diffCnt := 0
-	for i, res := range sub.RunResults {
-		outFile, err := os.ReadFile(outs[i])
-		if err != nil {
-			return err
+	if len(cfg.rubric) != 0 {
+		writeRubricCases(f, cases, sub, cfg)
+	} else {
+		f.WriteString("Test Cases:\n")
+		for i, res := range sub.RunResults {
+			writeCase(f, cases[i].Out, res, cfg)
 		}
-		outText := strings.ReplaceAll(string(outFile), "\r", "")
+	}
 
-		// Error log
-		f.WriteString(fmt.Sprintf("\nCase %s: %s\n", outs[i], res.Status))
-		if res.Status == STATUS_ERR {
-			f.WriteString("Error Log:\n")
-			if !verbose {
-				f.WriteString(truncLines(res.err, VerboseNumLines) + "\n\n")
-			} else {
-				f.WriteString(res.err + "\n\n")
-			}
-			continue
-		}
+	diffCnt, formatCnt := mismatchCounts(sub)
 
-		// Diff log
-		dmp := diffmatchpatch.New()
-		diffs := dmp.DiffMain(outText, res.out, false)
-		diff := dmp.DiffPrettyText(diffs)
-		if diff != outText {
-			diffCnt++
-			f.WriteString("Diff Log:\n\n")
-			if !verbose {
-				f.WriteString(truncLines(diff, VerboseNumLines))
-			} else {
-				f.WriteString(diff)
-			}
+	f.WriteString(fmt.Sprintf("\n\n---------------Number of mismatch test outputs: %d---------------\n", diffCnt))
+	if formatCnt != 0 {
+		f.WriteString(fmt.Sprintf("---------------Number of test outputs with invalid format: %d---------------\n", formatCnt))
+	}
+	f.WriteString("\n")
+	writeSources(f, sub)
+}
+
+func writeCase(f *bytes.Buffer, name string, res *Result, cfg *Config) {
+	verbose := cfg.Verbose
+
+	// Cases that were never run still get listed, with the reason why
+	if res.Status == STATUS_SKIPPED {
+		f.WriteString(fmt.Sprintf("\nCase %s: %s (%s)\n", name, res.Status, res.Reason()))
+		return
+	}
+
+	// Error log
+	if res.Crashed() && res.Reason() != "" {
+		f.WriteString(fmt.Sprintf("\nCase %s: %s (%s)\n", name, res.Status, res.Reason()))
+	} else {
+		f.WriteString(fmt.Sprintf("\nCase %s: %s\n", name, res.Status))
+	}
+	if res.Retried {
+		f.WriteString("(re-run once after the first run produced no output)\n")
+	}
+	if res.Attempts > 1 && res.Passed() {
+		f.WriteString(fmt.Sprintf("NOTE: only passed on attempt %d; the first run was %s. The submission may be flaky.\n", res.Attempts, res.FirstFailure()))
+	} else if res.Attempts > 1 {
+		f.WriteString(fmt.Sprintf("NOTE: ran %d times; the first run was %s.\n", res.Attempts, res.FirstFailure()))
+	}
+	if len(res.Args) != 0 {
+		f.WriteString(fmt.Sprintf("Args: %s\n", shellJoin(res.Args)))
+	}
+	if res.IgnoredInput() {
+		f.WriteString("NOTE: program did not read any input.\n")
+	}
+	if res.Note() != "" {
+		f.WriteString(fmt.Sprintf("NOTE: %s.\n", res.Note()))
+	}
+	if res.Status == STATUS_TIMEOUT && res.OwnLimit() {
+		f.WriteString(fmt.Sprintf("NOTE: this case has its own timeout of %gs.\n", res.Limit.Seconds()))
+	}
+	if res.Status == STATUS_OUTPUT_EXCEEDED {
+		f.WriteString("NOTE: program was stopped for printing too much; its output was cut off, so the diff may be incomplete.\n")
+	}
+	if res.Status != STATUS_OK {
+		f.WriteString(fmt.Sprintf("Exit: %s\n", res.exitDescription()))
+	}
+	if res.Crashed() {
+		f.WriteString("Error Log:\n")
+		if !verbose {
+			f.WriteString(truncLines(res.Err(), VerboseNumLines) + "\n\n")
 		} else {
-			f.WriteString("Diff Log: No Diff!\n\n")
-			continue
+			f.WriteString(res.Err() + "\n\n")
 		}
+		return
+	}
 
-		// Out log
-		f.WriteString("Out Log:\n\n")
+	if res.StderrMismatch() {
+		f.WriteString("Stderr Diff Log:\n\n")
 		if !verbose {
-			f.WriteString(truncLines(res.out, VerboseNumLines))
+			f.WriteString(truncLines(renderDiff(res.StderrDiffs(), cfg.diffMode(false)), VerboseNumLines))
 		} else {
-			f.WriteString(res.out)
+			f.WriteString(renderDiff(res.StderrDiffs(), cfg.diffMode(false)))
 		}
+		f.WriteString("\n\n")
 	}
 
-	f.WriteString(fmt.Sprintf("\n\n---------------Number of mismatch test outputs: %d---------------\n\n", diffCnt))
+	// Diff log
+	if res.FormatError() != "" {
+		f.WriteString(fmt.Sprintf("Diff Log: skipped, output format invalid: %s\n\n", res.FormatError()))
+	} else if !res.Match {
+		f.WriteString("Diff Log:\n\n")
+		if !verbose {
+			f.WriteString(truncLines(renderDiff(res.Diffs(), cfg.diffMode(false)), VerboseNumLines))
+		} else {
+			f.WriteString(renderDiff(res.Diffs(), cfg.diffMode(false)))
+		}
+	} else {
+		f.WriteString("Diff Log: No Diff!\n\n")
+		return
+	}
 
-	return nil
+	// Out log
+	f.WriteString("Out Log:\n\n")
+	if !verbose {
+		f.WriteString(truncLines(res.Out(), VerboseNumLines))
+	} else {
+		f.WriteString(res.Out())
+	}
 }
 
-func makeTestDir(path string) (dir string, class string) {
-	// Get class name
-	raw := strings.Split(strings.TrimSuffix(filepath.Base(path), ".java"), "_")
-	class = strings.Split(strings.Join(raw[3:], ""), "-")[0]
+func makeTestDir(path, dir, naming string) (class string, err error) {
+	class, err = javaClass(path, naming)
+	if err != nil {
+		return "", err
+	}
 
 	// Setup test folder
-	dir = strings.TrimSuffix(filepath.Base(path), ".java")
-	os.Mkdir(dir, 0777)
-	copy(path, filepath.Join(dir, class+".java"))
+	err = os.Mkdir(dir, 0777)
+	if err != nil {
+		return "", err
+	}
+	_, err = copyFile(path, filepath.Join(dir, class+".java"))
+	if err != nil {
+		return "", fmt.Errorf("could not copy %s into its test folder: %w", path, err)
+	}
+
+	return class, nil
+}
+
+// makeDirTestDir sets up a test folder from a submission folder, copying in
+// every .java file found in it so helper classes are compiled too. The class
+// to run is picked the same way as for zipped submissions.
+func makeDirTestDir(path, dir, naming string) (class string, err error) {
+	err = os.Mkdir(dir, 0777)
+	if err != nil {
+		return "", err
+	}
+
+	srcs := make([]string, 0)
+	err = filepath.Walk(path, func(src string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(src) != ".java" {
+			return err
+		}
+		srcs = append(srcs, filepath.Base(src))
+		_, err = copyFile(src, filepath.Join(dir, filepath.Base(src)))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(srcs) == 0 {
+		return "", fmt.Errorf("%s: folder has no .java files", path)
+	}
+	return mainClass(path, dir, srcs, naming), nil
+}
 
-	return dir, class
+// isJavaDir reports whether dir directly holds any .java files.
+func isJavaDir(dir string) bool {
+	srcs, err := filepath.Glob(filepath.Join(dir, "*.java"))
+	return err == nil && len(srcs) > 0
+}
+
+// makeExecDir sets up a test folder for a script or binary submission,
+// keeping its original filename and making sure it is executable.
+func makeExecDir(path, dir string) (prog string, err error) {
+	err = os.Mkdir(dir, 0777)
+	if err != nil {
+		return "", err
+	}
+
+	prog = filepath.Base(path)
+	_, err = copyFile(path, filepath.Join(dir, prog))
+	if err != nil {
+		return "", err
+	}
+	return prog, os.Chmod(filepath.Join(dir, prog), 0755)
+}
+
+// submissionName is the submission's filename without its extension.
+func submissionName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// dirNamer hands out unique test folder paths inside root. The suffixes come
+// from a seeded random source, so the same --seed always gives the same names.
+type dirNamer struct {
+	mu   sync.Mutex
+	root string
+	rng  *rand.Rand
+	used map[string]bool
+}
+
+func newDirNamer(seed int64, root string) *dirNamer {
+	return &dirNamer{root: root, rng: rand.New(rand.NewSource(seed)), used: make(map[string]bool)}
+}
+
+// name is safe to call concurrently, and never returns the same path twice.
+func (n *dirNamer) name(path string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for {
+		name := fmt.Sprintf("%s-%06x", submissionName(path), n.rng.Intn(1<<24))
+		if !n.used[name] {
+			n.used[name] = true
+			return filepath.Join(n.root, name)
+		}
+	}
 }
 
-func copy(src, dst string) (int64, error) {
+func copyFile(src, dst string) (int64, error) {
 	sourceFileStat, err := os.Stat(src)
 	if err != nil {
 		return 0, err
@@ -376,8 +1691,10 @@ func copy(src, dst string) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	defer destination.Close()
 	nBytes, err := io.Copy(destination, source)
+	if cerr := destination.Close(); err == nil {
+		err = cerr
+	}
 	return nBytes, err
 }
 
@@ -391,12 +1708,43 @@ func truncLines(output string, numLines int) string {
 	return strings.Join(ret, "")
 }
 
+// crashed reports whether the program failed to run or exited with an
+// error instead of finishing its output.
+func (r *Result) crashed() bool {
+	return r.Status == STATUS_ERR || r.Status == STATUS_RUNTIME_ERR || r.Status == STATUS_MEMORY
+}
+
+// graded reports whether the case's output was compared against the
+// expected output.
+func (r *Result) graded() bool {
+	return !r.crashed() && r.Status != STATUS_SKIPPED
+}
+
+// passed reports whether the case ran to completion and matched its
+// expected output.
+func (r *Result) passed() bool {
+	return r.Status == STATUS_OK && r.Match && !r.errMismatch
+}
+
+// ignoredInput reports whether the case had input that the program never
+// read, one of the most common novice mistakes.
+func (r *Result) ignoredInput() bool {
+	return r.Status != STATUS_SKIPPED && r.stdinSize > 0 && r.StdinRead == 0
+}
+
 type Status int64
 
 const (
 	STATUS_OK Status = iota
 	STATUS_ERR
 	STATUS_TIMEOUT
+	STATUS_SKIPPED
+	STATUS_OUTPUT_EXCEEDED
+	STATUS_WARN
+	STATUS_MEMORY
+	STATUS_COMPILE_ERR
+	STATUS_RUNTIME_ERR
+	STATUS_MEMORY_EXCEEDED
 )
 
 func (s Status) String() string {
@@ -407,6 +1755,20 @@ func (s Status) String() string {
 		return "ERROR"
 	case STATUS_TIMEOUT:
 		return "TIMEOUT"
+	case STATUS_SKIPPED:
+		return "SKIPPED"
+	case STATUS_OUTPUT_EXCEEDED:
+		return "OUTPUT LIMIT EXCEEDED"
+	case STATUS_WARN:
+		return "WARNING"
+	case STATUS_MEMORY:
+		return "MEMORY LIMIT EXCEEDED"
+	case STATUS_COMPILE_ERR:
+		return "COMPILE ERROR"
+	case STATUS_RUNTIME_ERR:
+		return "RUNTIME ERROR"
+	case STATUS_MEMORY_EXCEEDED:
+		return "PEAK MEMORY EXCEEDED"
 	}
 	return "UNKNOWN STATUS"
 }
@@ -415,10 +1777,119 @@ type Submission struct {
 	Name          string
 	CompileResult *Result
 	RunResults    []*Result
+	Score         float64 // percentage of MaxPoints earned, after any late penalty
+	Points        float64 // points earned, before any late penalty
+	MaxPoints     float64
+	Stray         []string
+	Failure       string // why the submission couldn't be run, if it couldn't
+	Incomplete    bool   // the run was stopped before every case ran
+	Sources       []SourceFile
+	DuplicateOf   string // the submission whose files this one's are identical to
+
+	SubmittedAt time.Time
+	RawScore    float64
+	DaysLate    int
+	LatePenalty float64
+}
+
+// compileFailed reports whether the submission was compiled and failed to
+// compile. Submissions in interpreted languages have no CompileResult.
+func (s *Submission) compileFailed() bool {
+	return s.CompileResult != nil && s.CompileResult.Status == STATUS_COMPILE_ERR
+}
+
+// compiled reports whether the submission got as far as running its cases.
+func (s *Submission) compiled() bool {
+	return s.Failure == "" && !s.compileFailed()
 }
 
 type Result struct {
-	Status Status
-	out    string
-	err    string
+	Status   Status
+	Match    bool
+	Duration time.Duration
+	Limit    time.Duration // the timeout the case ran under
+	ownLimit bool          // Limit is the case's own timeout, not --timeout
+	Retried  bool
+	Attempts int            // how many times --retries ran the case, 0 if just once
+	ExitCode int            // -1 if the process didn't exit on its own
+	Signal   syscall.Signal // what ended the process, if a signal did
+	MemoryKB int64          // peak resident memory, 0 if unknown
+	out      string
+	err      string
+	diffs    []diffmatchpatch.Diff
+	reason   string
+
+	errDiffs    []diffmatchpatch.Diff // stderr against the case's .err file
+	errMismatch bool
+
+	formatErr    string
+	note         string
+	firstFailure string // how the first run went, if it was retried
+
+	StdinRead int64
+	stdinSize int64
+
+	Args []string // the program's command line arguments, from the case's .args file
 }
+
+// The rest of a Result is only set while running and grading the case, and
+// is read through these by anything reporting on it.
+
+// Out is what the program printed to stdout, or for a compile, the
+// compiler.
+func (r *Result) Out() string { return r.out }
+
+// Err is what was printed to stderr.
+func (r *Result) Err() string { return r.err }
+
+// Reason says why the case was skipped or didn't finish, if it wasn't OK.
+func (r *Result) Reason() string { return r.reason }
+
+// Note is the grader's remark on how the output matched, if any.
+func (r *Result) Note() string { return r.note }
+
+// FormatError says how the output broke --schema, if it did.
+func (r *Result) FormatError() string { return r.formatErr }
+
+// Diffs is the diff of the output against the expected output, nil if it
+// wasn't worked out.
+func (r *Result) Diffs() []diffmatchpatch.Diff { return r.diffs }
+
+// StderrDiffs is the diff of stderr against the case's .err file, nil if it
+// has none.
+func (r *Result) StderrDiffs() []diffmatchpatch.Diff { return r.errDiffs }
+
+// Passed reports whether the case ran to completion and matched its
+// expected output.
+func (r *Result) Passed() bool { return r.passed() }
+
+// Graded reports whether the output was compared against the expected
+// output.
+func (r *Result) Graded() bool { return r.graded() }
+
+// Crashed reports whether the program failed to run or exited with an error.
+func (r *Result) Crashed() bool { return r.crashed() }
+
+// StderrMismatch reports whether stderr didn't match the case's .err file.
+func (r *Result) StderrMismatch() bool { return r.errMismatch }
+
+// IgnoredInput reports whether the program never read the input it was
+// given, as far as the executor can tell.
+func (r *Result) IgnoredInput() bool { return r.ignoredInput() }
+
+// StdinSize is the size of the case's input, or 0 if how much was read
+// couldn't be tracked.
+func (r *Result) StdinSize() int64 { return r.stdinSize }
+
+// OwnLimit reports whether the case ran under its own timeout rather than
+// --timeout.
+func (r *Result) OwnLimit() bool { return r.ownLimit }
+
+// FirstFailure is how the first run of a retried case went.
+func (r *Result) FirstFailure() string { return r.firstFailure }
+
+// Compiled reports whether the submission got as far as running its cases.
+func (s *Submission) Compiled() bool { return s.compiled() }
+
+// CompileFailed reports whether the submission didn't compile.
+func (s *Submission) CompileFailed() bool { return s.compileFailed() }
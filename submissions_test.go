@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// testConfig builds a Config the way the command line does, from args.
+func testConfig(t *testing.T, args ...string) *Config {
+	t.Helper()
+	var cfg *Config
+	app := &cli.App{
+		Flags: appFlags(),
+		Action: func(c *cli.Context) error {
+			var err error
+			cfg, err = configFromContext(c)
+			return err
+		},
+	}
+	err := app.Run(append([]string{"submissioncheck", "--quiet", "--log-level", "error"}, args...))
+	if err != nil {
+		t.Fatalf("building config from %q: %v", args, err)
+	}
+	return cfg
+}
+
+// writeFile writes text to path, making any folders it needs.
+func writeFile(t *testing.T, path, text string, perm os.FileMode) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(text), perm)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunSubmissionCapturesStdout(t *testing.T) {
+	tmp := t.TempDir()
+	sub := filepath.Join(tmp, "submissions", "abc_1_2_echo.sh")
+	writeFile(t, sub, "#!/bin/sh\ncat\necho done\n", 0755)
+	writeFile(t, filepath.Join(tmp, "testcases", "1.in"), "1 2\n", 0644)
+	writeFile(t, filepath.Join(tmp, "testcases", "1.out"), "1 2\ndone\n", 0644)
+
+	cfg := testConfig(t, "--path", tmp)
+	cases := []TestCase{{
+		In:  filepath.Join(tmp, "testcases", "1.in"),
+		Out: filepath.Join(tmp, "testcases", "1.out"),
+	}}
+	got, err := runSubmission(context.Background(), sub, filepath.Join(tmp, "work"), cases, nil, cfg)
+	if err != nil {
+		t.Fatalf("runSubmission: %v", err)
+	}
+	if len(got.RunResults) != 1 {
+		t.Fatalf("got %d results, want 1", len(got.RunResults))
+	}
+	res := got.RunResults[0]
+	if res.Status != STATUS_OK {
+		t.Errorf("status = %v, want %v (stderr %q)", res.Status, STATUS_OK, res.Err())
+	}
+	if want := "1 2\ndone\n"; res.Out() != want {
+		t.Errorf("stdout = %q, want %q", res.Out(), want)
+	}
+}
+
+func TestRunSubmissionPeakMemoryExceeded(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	tmp := t.TempDir()
+	sub := filepath.Join(tmp, "submissions", "abc_1_2_hog.py")
+	writeFile(t, sub, "data = bytearray(64 << 20)\nprint(len(data))\n", 0644)
+	writeFile(t, filepath.Join(tmp, "testcases", "1.in"), "", 0644)
+	writeFile(t, filepath.Join(tmp, "testcases", "1.out"), "67108864\n", 0644)
+	cases := []TestCase{{
+		In:  filepath.Join(tmp, "testcases", "1.in"),
+		Out: filepath.Join(tmp, "testcases", "1.out"),
+	}}
+
+	tests := []struct {
+		limit string
+		want  Status
+	}{
+		{"0", STATUS_OK},
+		{"16", STATUS_MEMORY_EXCEEDED},
+		{"1024", STATUS_OK},
+	}
+	for _, tt := range tests {
+		cfg := testConfig(t, "--path", tmp, "--max-memory-mb", tt.limit)
+		got, err := runSubmission(context.Background(), sub, filepath.Join(tmp, "work-"+tt.limit), cases, nil, cfg)
+		if err != nil {
+			t.Fatalf("runSubmission: %v", err)
+		}
+		res := got.RunResults[0]
+		if res.Status != tt.want {
+			t.Errorf("--max-memory-mb %s: status = %v (peak %d KB), want %v", tt.limit, res.Status, res.MemoryKB, tt.want)
+		}
+	}
+}
+
+func TestRunCountsEachUnwritableReportOnce(t *testing.T) {
+	tmp := t.TempDir()
+	for _, name := range []string{"abc_1_2_one", "def_1_2_two", "ghi_1_2_three", "jkl_1_2_four"} {
+		writeFile(t, filepath.Join(tmp, "submissions", name+".sh"), "#!/bin/sh\ncat\n", 0755)
+	}
+	writeFile(t, filepath.Join(tmp, "testcases", "1.in"), "1 2\n", 0644)
+	writeFile(t, filepath.Join(tmp, "testcases", "1.out"), "1 2\n", 0644)
+
+	// A folder in the way of a report can't be replaced by it. Old enough
+	// not to count as a current report, it gets the submission regraded.
+	for _, name := range []string{"def_1_2_two", "jkl_1_2_four"} {
+		blocker := filepath.Join(tmp, "reports", name+".txt")
+		writeFile(t, filepath.Join(blocker, "keep"), "", 0644)
+		epoch := time.Unix(0, 0)
+		err := os.Chtimes(blocker, epoch, epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := testConfig(t, "--path", tmp, "--incremental", "--report-workers", "3")
+	done := make(chan error, 1)
+	go func() { done <- run(cfg) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("run did not finish")
+	}
+	if err == nil || !strings.Contains(err.Error(), "2 of 4 report(s) could not be written") {
+		t.Fatalf("run() = %v, want 2 of 4 reports unwritten", err)
+	}
+	for _, name := range []string{"abc_1_2_one", "ghi_1_2_three"} {
+		if _, err := os.Stat(filepath.Join(tmp, "reports", name+".txt")); err != nil {
+			t.Errorf("report for %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CaseFilesExt marks a folder of auxiliary files for one test case: the
+// contents of testcases/case3.files are copied next to the program before
+// case3 runs and removed again afterwards.
+const CaseFilesExt = ".files"
+
+// compressedExts are the compression suffixes test files may carry, e.g.
+// case3.out.gz. Such files are decompressed transparently when read.
+var compressedExts = []string{".gz", ".bz2"}
+
+// trimCompressedExt strips a compression suffix from a test file path.
+func trimCompressedExt(path string) string {
+	for _, ext := range compressedExts {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext)
+		}
+	}
+	return path
+}
+
+// TestCase is an input file and the output expected for it, and how many
+// points passing it is worth. Err is the expected stderr, if the case checks
+// it.
+type TestCase struct {
+	In, Out, Err string
+	Alts         []string // other accepted outputs, from <name>.out.1, <name>.out.2, ...
+	Args         []string // command line arguments, from <name>.args
+	Points       float64
+}
+
+// outputs lists every output the case accepts, Out first.
+func (tc TestCase) outputs() []string {
+	return append([]string{tc.Out}, tc.Alts...)
+}
+
+// altOutput splits a <name>.out.<n> path into its name and n.
+func altOutput(path string) (name string, n int, ok bool) {
+	ext := filepath.Ext(path)
+	n, err := strconv.Atoi(strings.TrimPrefix(ext, "."))
+	if err != nil || !strings.HasSuffix(strings.TrimSuffix(path, ext), ".out") {
+		return "", 0, false
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(path, ext), ".out"), n, true
+}
+
+// getTestCases pairs every <name>.in in testsDir with its <name>.out, and
+// <name>.err if there is one, in natural order of the names so that case 2
+// comes before case 10. A case with several right answers can have them as
+// <name>.out.1, <name>.out.2 and so on, with or without a <name>.out.
+func getTestCases(testsDir string) ([]TestCase, error) {
+	in := make(map[string]string)
+	out := make(map[string]string)
+	errs := make(map[string]string)
+	alts := make(map[string][]string)
+	altNums := make(map[string]int)
+	empty := make(map[string]bool)
+	argFiles := make(map[string]string)
+	err := filepath.Walk(testsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			// Per-case auxiliary files aren't test cases themselves
+			if strings.HasSuffix(path, CaseFilesExt) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		name := trimCompressedExt(path)
+		if info.Size() == 0 {
+			empty[path] = true
+		}
+		if stem, n, ok := altOutput(name); ok {
+			alts[stem] = append(alts[stem], path)
+			altNums[path] = n
+			return nil
+		}
+		switch filepath.Ext(name) {
+		case ".in":
+			in[strings.TrimSuffix(name, ".in")] = path
+		case ".out":
+			out[strings.TrimSuffix(name, ".out")] = path
+		case ".err":
+			errs[strings.TrimSuffix(name, ".err")] = path
+		case ArgsExt:
+			argFiles[strings.TrimSuffix(name, ArgsExt)] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for stem, paths := range alts {
+		sort.Slice(paths, func(i, j int) bool {
+			return altNums[paths[i]] < altNums[paths[j]]
+		})
+		if out[stem] == "" {
+			out[stem], paths = paths[0], paths[1:]
+		}
+		alts[stem] = paths
+	}
+
+	names := make([]string, 0, len(in))
+	for name := range in {
+		if out[name] == "" {
+			return nil, fmt.Errorf("test case %s has no matching .out file", in[name])
+		}
+		names = append(names, name)
+	}
+	for name, path := range out {
+		if in[name] == "" {
+			logWarn(logFields{"file": path}, "%s has no matching .in file and will not be run", path)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return naturalLess(names[i], names[j])
+	})
+
+	cases := make([]TestCase, 0, len(names))
+	for _, name := range names {
+		tc := TestCase{In: in[name], Out: out[name], Err: errs[name], Alts: alts[name]}
+		if path, ok := argFiles[name]; ok {
+			tc.Args, err = readArgs(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		cases = append(cases, tc)
+	}
+
+	// An empty expected output is usually a file that never got filled in,
+	// and would fail every submission that prints anything
+	for _, tc := range cases {
+		for _, path := range tc.outputs() {
+			if empty[path] {
+				logWarn(logFields{"file": path}, "%s is empty, so only submissions that print nothing will pass %s", path, tc.In)
+			}
+		}
+	}
+	return cases, nil
+}
+
+// naturalLess compares strings with runs of digits ordered by their value,
+// so "case2" sorts before "case10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+type testFileReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *testFileReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if cerr := r.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openTestFile opens a test input or output file, decompressing it if it
+// ends in .gz or .bz2.
+func openTestFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(path) {
+	case ".gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &testFileReader{Reader: gz, closers: []io.Closer{f, gz}}, nil
+	case ".bz2":
+		return &testFileReader{Reader: bzip2.NewReader(f), closers: []io.Closer{f}}, nil
+	}
+	return f, nil
+}
+
+// readTestFile reads a whole test file, decompressing it if needed.
+func readTestFile(path string) ([]byte, error) {
+	r, err := openTestFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// openStdin opens a test input to hand to a program as its stdin. The
+// program gets a real file rather than a pipe so that, once it exits, the
+// file offset tells how much input it actually read (a counting reader on a
+// pipe would only see the copy into the pipe buffer). Compressed inputs are
+// decompressed into a temporary file first; cleanup removes it.
+func openStdin(path string) (f *os.File, size int64, cleanup func(), err error) {
+	if trimCompressedExt(path) == path {
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, nil, err
+		}
+		return f, info.Size(), func() { f.Close() }, nil
+	}
+
+	r, err := openTestFile(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer r.Close()
+
+	f, err = os.CreateTemp("", "stdin-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	size, err = io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return f, size, cleanup, nil
+}
+
+// ArgsExt marks a file with a case's command line arguments, e.g.
+// testcases/3.args for testcases/3.in. A single line is split like a shell
+// would, quotes included; with more lines, each line is one argument.
+const ArgsExt = ".args"
+
+func readArgs(path string) ([]string, error) {
+	data, err := readTestFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if strings.Contains(text, "\n") {
+		return strings.Split(text, "\n"), nil
+	}
+	args, err := splitArgs(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return args, nil
+}
+
+// splitArgs splits line into words the way a shell would, with single and
+// double quotes and backslash escapes, but no expansions.
+func splitArgs(line string) ([]string, error) {
+	args := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\\' && i+1 < len(line) && (quote == 0 || strings.IndexByte("\"\\$`", line[i+1]) >= 0):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// shellJoin quotes args so they can be pasted into a shell to rerun a case.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+.,/:@%") == "" {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func caseFilesDir(inFile string) string {
+	return strings.TrimSuffix(trimCompressedExt(inFile), ".in") + CaseFilesExt
+}
+
+// caseMetaFile is the optional key=value file with per-case settings, e.g.
+// testcases/5.meta for testcases/5.in.
+func caseMetaFile(inFile string) string {
+	return strings.TrimSuffix(trimCompressedExt(inFile), ".in") + MetaExt
+}
+
+// CaseTimeoutsFile maps case names to timeouts in seconds for a whole
+// testcases folder, e.g. {"stress1": 20, "stress2": 20}.
+const CaseTimeoutsFile = "timeouts.json"
+
+// TimeoutExt marks a file holding just one case's timeout in seconds, e.g.
+// testcases/stress1.timeout for testcases/stress1.in.
+const TimeoutExt = ".timeout"
+
+// readCaseTimeouts reads the per-case timeouts, in seconds, from testsDir's
+// timeouts.json and each case's .timeout or .meta file (timeout=), with the
+// case's own files taking precedence. Cases without one aren't in the map and
+// use the global timeout.
+func readCaseTimeouts(testsDir string, cases []TestCase) (map[string]int, error) {
+	byName := make(map[string]int)
+	path := filepath.Join(testsDir, CaseTimeoutsFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &byName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for name, secs := range byName {
+		if secs <= 0 {
+			return nil, fmt.Errorf("%s: timeout for %s must be a positive number of seconds, got %d", path, name, secs)
+		}
+	}
+
+	timeouts := make(map[string]int)
+	for _, tc := range cases {
+		inFile := tc.In
+		if secs, ok := byName[caseName(tc.Out)]; ok {
+			timeouts[inFile] = secs
+		}
+
+		stem := strings.TrimSuffix(trimCompressedExt(inFile), ".in")
+		data, err := os.ReadFile(stem + TimeoutExt)
+		if err == nil {
+			secs, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil || secs <= 0 {
+				return nil, fmt.Errorf("%s: timeout must be a whole number of seconds, got %q", stem+TimeoutExt, strings.TrimSpace(string(data)))
+			}
+			timeouts[inFile] = secs
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		meta, err := readMeta(caseMetaFile(inFile))
+		if err != nil {
+			return nil, err
+		}
+		timeout, ok := meta["timeout"]
+		if !ok {
+			continue
+		}
+		secs, err := strconv.Atoi(timeout)
+		if err != nil || secs <= 0 {
+			return nil, fmt.Errorf("%s: timeout must be a whole number of seconds, got %q", caseMetaFile(inFile), timeout)
+		}
+		timeouts[inFile] = secs
+	}
+	return timeouts, nil
+}
+
+// CasePointsFile maps case names to how many points they are worth, e.g.
+// {"basic": 1, "stress": 5}.
+const CasePointsFile = "points.json"
+
+// CaseWeightsFile is accepted in place of CasePointsFile, for suites that
+// call them weights.
+const CaseWeightsFile = "weights.json"
+
+// PointsExt marks a file holding just one case's points, e.g.
+// testcases/stress.pts for testcases/stress.in.
+const PointsExt = ".pts"
+
+// readCasePoints sets every case's points from testsDir's points.json (or
+// weights.json) and the cases' own .pts files, which take precedence. Other
+// cases are worth 1.
+func readCasePoints(testsDir string, cases []TestCase) error {
+	byName := make(map[string]float64)
+	found := ""
+	for _, file := range []string{CasePointsFile, CaseWeightsFile} {
+		path := filepath.Join(testsDir, file)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if found != "" {
+			return fmt.Errorf("%s and %s both set case points; keep only one", found, path)
+		}
+		found = path
+
+		err = json.Unmarshal(data, &byName)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for name, pts := range byName {
+			if pts < 0 {
+				return fmt.Errorf("%s: points for %s can't be negative, got %g", path, name, pts)
+			}
+		}
+	}
+
+	for i := range cases {
+		cases[i].Points = 1
+		if pts, ok := byName[caseName(cases[i].Out)]; ok {
+			cases[i].Points = pts
+		}
+
+		ptsFile := strings.TrimSuffix(trimCompressedExt(cases[i].In), ".in") + PointsExt
+		data, err := os.ReadFile(ptsFile)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		pts, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		if err != nil || pts < 0 {
+			return fmt.Errorf("%s: points must be a non-negative number, got %q", ptsFile, strings.TrimSpace(string(data)))
+		}
+		cases[i].Points = pts
+	}
+	return nil
+}
+
+// copyCaseFiles copies a case's auxiliary files (if it has any) into dir and
+// returns the paths it created, relative to dir, so they can be removed once
+// the case is done. Files the submission already has are left as they are.
+func copyCaseFiles(inFile, dir string) ([]string, error) {
+	src := caseFilesDir(inFile)
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	copied := make([]string, 0)
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		// The submission's own files win, and are left for later cases
+		dst := filepath.Join(dir, rel)
+		if existing, err := os.Lstat(dst); err == nil {
+			if info.IsDir() && existing.IsDir() {
+				return nil
+			}
+			logWarn(logFields{"file": path, "dst": dst}, "%s is not copied in for this case: the submission has its own %s", path, rel)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			err = os.Mkdir(dst, 0777)
+		} else {
+			_, err = copyFile(path, dst)
+		}
+		// Anything there now is ours, even if copying it failed part way
+		if _, lerr := os.Lstat(dst); lerr == nil {
+			copied = append(copied, rel)
+		}
+		return err
+	})
+	return copied, err
+}
+
+// removeCaseFiles undoes copyCaseFiles.
+func removeCaseFiles(dir string, copied []string) {
+	// Deepest first, so folders are emptied before they're removed
+	for i := len(copied) - 1; i >= 0; i-- {
+		os.RemoveAll(filepath.Join(dir, copied[i]))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGetTestCasesNaturalOrder(t *testing.T) {
+	dir := t.TempDir()
+	// Written in an order that is neither natural nor lexical
+	for _, n := range []int{10, 3, 12, 1, 7, 2, 11, 5, 9, 4, 8, 6} {
+		name := filepath.Join(dir, "case"+strconv.Itoa(n))
+		for _, ext := range []string{".in", ".out"} {
+			err := os.WriteFile(name+ext, []byte("x\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cases, err := getTestCases(dir)
+	if err != nil {
+		t.Fatalf("getTestCases: %v", err)
+	}
+	if len(cases) != 12 {
+		t.Fatalf("got %d cases, want 12", len(cases))
+	}
+	for i, tc := range cases {
+		want := "case" + strconv.Itoa(i+1)
+		if got := filepath.Base(tc.In); got != want+".in" {
+			t.Errorf("case %d is %s, want %s.in", i+1, got, want)
+		}
+		if got := filepath.Base(tc.Out); got != want+".out" {
+			t.Errorf("case %d expects %s, want %s.out", i+1, got, want)
+		}
+	}
+}
+
+func TestCopyCaseFilesKeepsSubmissionFiles(t *testing.T) {
+	tmp := t.TempDir()
+	tests := filepath.Join(tmp, "testcases")
+	dir := filepath.Join(tmp, "work")
+	writeFile(t, filepath.Join(tests, "1.in"), "", 0644)
+	writeFile(t, filepath.Join(tests, "1"+CaseFilesExt, "data.txt"), "case data\n", 0644)
+	writeFile(t, filepath.Join(tests, "1"+CaseFilesExt, "extra.txt"), "extra\n", 0644)
+	writeFile(t, filepath.Join(tests, "1"+CaseFilesExt, "lib", "util.txt"), "util\n", 0644)
+	writeFile(t, filepath.Join(dir, "data.txt"), "student data\n", 0644)
+	writeFile(t, filepath.Join(dir, "lib", "mine.txt"), "mine\n", 0644)
+
+	copied, err := copyCaseFiles(filepath.Join(tests, "1.in"), dir)
+	if err != nil {
+		t.Fatalf("copyCaseFiles: %v", err)
+	}
+	if got, want := strings.Join(copied, ","), "extra.txt,"+filepath.Join("lib", "util.txt"); got != want {
+		t.Errorf("copied %s, want %s", got, want)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "data.txt"))
+	if err != nil || string(data) != "student data\n" {
+		t.Errorf("submission's data.txt = %q, %v; want it untouched", data, err)
+	}
+
+	removeCaseFiles(dir, copied)
+	for _, name := range []string{"data.txt", filepath.Join("lib", "mine.txt")} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("submission's %s is gone after the case: %v", name, err)
+		}
+	}
+	for _, name := range []string{"extra.txt", filepath.Join("lib", "util.txt")} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("case file %s is still there after the case", name)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// WatchInterval is how often --watch checks the submissions folder.
+const WatchInterval = 2 * time.Second
+
+// modTimes records when each submission last changed.
+func modTimes(paths []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		times[path] = newestModTime(path)
+	}
+	return times
+}
+
+// watchSubmissions polls subDir and regrades every submission that appears or
+// changes after seen recorded it, replacing its reports. It returns when ctx
+// is cancelled, or if the submissions folder can no longer be read.
+func watchSubmissions(ctx context.Context, subDir, repDir string, seen map[string]time.Time, cases []TestCase, timeouts map[string]int, cfg *Config, namer *dirNamer) error {
+	logInfo(logFields{"dir": subDir}, "Watching %s for new or changed submissions (Ctrl-C to stop)...", subDir)
+	for {
+		select {
+		case <-ctx.Done():
+			logInfo(nil, "Stopped watching.")
+			return nil
+		case <-time.After(WatchInterval):
+		}
+		jobs, _, err := findSubmissions(subDir, cfg)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range jobs {
+			changed := newestModTime(path)
+			last, ok := seen[path]
+			if ok && !changed.After(last) {
+				continue
+			}
+			seen[path] = changed
+
+			if ok {
+				logInfo(logFields{"path": path, "change": "modified"}, "%s changed, regrading it...", path)
+			} else {
+				logInfo(logFields{"path": path, "change": "added"}, "%s was added, grading it...", path)
+			}
+			err = regradeSubmission(ctx, path, repDir, cases, timeouts, cfg, namer)
+			if err != nil {
+				logWarn(logFields{"path": path, "error": err}, "could not regrade %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// regradeSubmission grades a single submission and rewrites its reports. The
+// report size budget and the summary files only apply to full runs.
+func regradeSubmission(ctx context.Context, path, repDir string, cases []TestCase, timeouts map[string]int, cfg *Config, namer *dirNamer) error {
+	subs, failures := runSubmissions(ctx, []string{path}, cases, timeouts, cfg, namer)
+	sub := subs[0]
+	err := gradeSubmission(sub, cases, cfg)
+	if err != nil {
+		return err
+	}
+	applyLatePenalty(sub, cfg)
+	logVerdicts(sub, cases)
+	metrics.observe(sub)
+	err = history.record(cases, []*Submission{sub})
+	if err != nil {
+		logWarn(logFields{"db": cfg.DB, "error": err}, "could not save results to %s: %v", cfg.DB, err)
+	}
+
+	var report *bytes.Buffer
+	if cfg.writesText() {
+		report = &bytes.Buffer{}
+		renderReport(report, cases, sub, cfg)
+	}
+	err = writeReports(repDir, cases, sub, report, cfg)
+	if err != nil {
+		return err
+	}
+	if len(failures) != 0 {
+		return failures[0]
+	}
+	return nil
+}
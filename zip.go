@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ZipExt marks a Java submission uploaded as an archive of its sources
+const ZipExt = ".zip"
+
+var mainMethod = regexp.MustCompile(`public\s+static\s+void\s+main\s*\(`)
+
+// makeZipTestDir sets up a test folder from a zipped Java submission and
+// returns the class to run. The .java files are put at the top of the folder
+// so they compile together; everything else keeps its place in the archive.
+func makeZipTestDir(path, dir, naming string) (class string, err error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	err = os.Mkdir(dir, 0777)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := commonZipDir(r.File)
+	srcs := make([]string, 0)
+	for _, zf := range r.File {
+		name := strings.TrimPrefix(zf.Name, prefix)
+		if zf.FileInfo().IsDir() || name == "" || strings.HasPrefix(name, "__MACOSX/") {
+			continue
+		}
+
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if filepath.Ext(name) == ".java" {
+			dst = filepath.Join(dir, filepath.Base(dst))
+			srcs = append(srcs, filepath.Base(dst))
+		}
+		if !strings.HasPrefix(dst, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return "", fmt.Errorf("%s: %s is outside the archive", path, zf.Name)
+		}
+
+		err = extractZipFile(zf, dst)
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(srcs) == 0 {
+		return "", fmt.Errorf("%s: archive has no .java files", path)
+	}
+
+	return mainClass(path, dir, srcs, naming), nil
+}
+
+// commonZipDir is the folder every entry of the archive is in, if any, since
+// zipping a project folder puts it at the top of every path.
+func commonZipDir(files []*zip.File) string {
+	prefix := ""
+	for _, zf := range files {
+		if strings.HasPrefix(zf.Name, "__MACOSX/") {
+			continue
+		}
+		slash := strings.Index(zf.Name, "/")
+		if slash < 0 {
+			return ""
+		}
+		if prefix == "" {
+			prefix = zf.Name[:slash+1]
+		} else if zf.Name[:slash+1] != prefix {
+			return ""
+		}
+	}
+	return prefix
+}
+
+func extractZipFile(zf *zip.File, dst string) error {
+	err := os.MkdirAll(filepath.Dir(dst), 0777)
+	if err != nil {
+		return err
+	}
+
+	source, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+	_, err = io.Copy(destination, source)
+	return err
+}
+
+// mainClass picks which of the extracted sources to run: the only one, or the
+// only one with a main method. Anything more ambiguous falls back to the class
+// named in the filename, with a warning.
+func mainClass(path, dir string, srcs []string, naming string) string {
+	sort.Strings(srcs)
+	if len(srcs) == 1 {
+		return strings.TrimSuffix(srcs[0], ".java")
+	}
+
+	mains := make([]string, 0)
+	for _, src := range srcs {
+		data, err := os.ReadFile(filepath.Join(dir, src))
+		if err == nil && mainMethod.Match(data) {
+			mains = append(mains, strings.TrimSuffix(src, ".java"))
+		}
+	}
+	if len(mains) == 1 {
+		return mains[0]
+	}
+
+	candidates := mains
+	if len(mains) == 0 {
+		candidates = make([]string, 0, len(srcs))
+		for _, src := range srcs {
+			candidates = append(candidates, strings.TrimSuffix(src, ".java"))
+		}
+	}
+	class := candidates[0]
+	named, _ := className(path, naming)
+	for _, c := range candidates {
+		if c == named {
+			class = c
+		}
+	}
+	if len(mains) == 0 {
+		logWarn(logFields{"path": path, "class": class}, "%s: no class declares main; trying %s", filepath.Base(path), class)
+		return class
+	}
+	logWarn(logFields{"path": path, "mains": mains, "class": class}, "%s has %d classes with a main method, running %s", filepath.Base(path), len(mains), class)
+	return class
+}